@@ -2,26 +2,34 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
 type COD_chaincode struct {
 }
 
+//participants are keyed by their identity, msp id and enrollment id, names are only shown to people
 type Asset struct {
 	ObjectType string `json:"docType"`
+	//identity of the seller
 	Name       string `json:"name"`
+	SellerName string `json:"sellername"`
 	Asset      string `json:"asset"`
 	Quantity   int    `json:"quantity"`
 	Price      int    `json:"price"`
+	Reserved   int    `json:"reserved"`
 }
 
 type OrderHash struct {
@@ -31,30 +39,112 @@ type OrderHash struct {
 }
 
 type Balance struct {
-	ObjectType string `json:"docType"`
-	Name       string `json:"name"`
-	Balance    int    `json:"balance"`
+	ObjectType  string         `json:"docType"`
+	Name        string         `json:"name"`
+	DisplayName string         `json:"displayname"`
+	Balance     int            `json:"balance"`
+	Held        int            `json:"held"`
+	Holds       map[string]int `json:"holds,omitempty"`
+}
+
+type Collateral struct {
+	Name        string         `json:"name"`
+	DisplayName string         `json:"displayname"`
+	Balance     int            `json:"balance"`
+	Held        int            `json:"held"`
+	Available   int            `json:"available"`
+	Holds       map[string]int `json:"holds"`
 }
 
 type Customer struct {
-	ObjectType string `json:"docType"`
-	Name       string `json:"name"`
-	Location   string `json:"location"`
-	Number     string `json:"number"`
-	Email      string `json:"email"`
+	ObjectType  string `json:"docType"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayname"`
+	Location    string `json:"location"`
+	Number      string `json:"number"`
+	Email       string `json:"email"`
 }
 
+//orders are in orderCollection, which the shippers' org reads too: a shipper accepts, picks up, carries and settles
+//an order by its parties, items and amount, the contact of the customer stays in customerCollection
 type Order struct {
+	ObjectType string      `json:"docType"`
+	OrderID    string      `json:"orderid"`
+	Customer   string      `json:"customer"`
+	Seller     string      `json:"seller"`
+	Delivery   string      `json:"delivery"`
+	Items      []OrderItem `json:"items"`
+	Detail     string      `json:"detail"`
+	Price      int         `json:"price"`
+	Fee        int         `json:"fee"`
+	Status     string      `json:"status"`
+	UpdatedBy  string      `json:"updatedby"`
+	UpdatedAt  string      `json:"updatedat"`
+	//quantity of the order is reserved on the seller's asset
+	StockReserved bool     `json:"stockreserved"`
+	Refusal       *Refusal `json:"refusal,omitempty"`
+	//set when the order was cancelled, one cancelled after pickup still goes back and ends Settled
+	Cancellation *Cancellation `json:"cancellation,omitempty"`
+	//number of events in the history of the order, the next event gets it as sequence
+	Events int `json:"events"`
+}
+
+type OrderEvent struct {
 	ObjectType string `json:"docType"`
 	OrderID    string `json:"orderid"`
-	Customer   string `json:"customer"`
-	Seller     string `json:"seller"`
-	Delivery   string `json:"delivery"`
-	AssetName  string `json:"assetname"`
+	Sequence   int    `json:"sequence"`
+	Event      string `json:"event"`
+	Status     string `json:"status"`
 	Detail     string `json:"detail"`
-	Quantity   int    `json:"quantity"`
-	Price      int    `json:"price"`
+	Caller     string `json:"caller"`
+	TxID       string `json:"txid"`
+	Time       string `json:"time"`
+}
+
+type OrderItem struct {
+	Asset     string `json:"asset"`
+	Variant   string `json:"variant"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int    `json:"unitprice"`
+}
+
+type Refusal struct {
+	Location string `json:"location"`
+	Reason   string `json:"reason"`
+	Time     string `json:"time"`
+}
+
+type Config struct {
+	ObjectType string `json:"docType"`
+	ReturnFee  int    `json:"returnfee"`
+	//balance collection of each org, by msp id
+	BalanceCollections map[string]string `json:"balancecollections"`
+}
+
+//money on its way between two orgs, kept in mortgageCollection which both orgs can read
+type Transfer struct {
+	ObjectType string `json:"docType"`
+	TransferID string `json:"transferid"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Amount     int    `json:"amount"`
+	OrderID    string `json:"orderid,omitempty"`
 	Status     string `json:"status"`
+	UpdatedBy  string `json:"updatedby"`
+	UpdatedAt  string `json:"updatedat"`
+	//paid by the chaincode for an order, it can only be claimed
+	Payment bool `json:"payment,omitempty"`
+}
+
+type Cancellation struct {
+	ObjectType  string `json:"docType"`
+	OrderID     string `json:"orderid"`
+	Party       string `json:"party"`
+	CancelledBy string `json:"cancelledby"`
+	Reason      string `json:"reason"`
+	Stage       string `json:"stage"`
+	Fee         int    `json:"fee"`
+	Time        string `json:"time"`
 }
 
 type ImageAsByte struct {
@@ -64,22 +154,123 @@ type ImageAsByte struct {
 }
 
 type Delivery struct {
-	ObjectType string `json:"docType"`
-	Name       string `json:"name"`
-	Location   string `json:"location"`
-	Price      int    `json:"price"`
-	Distance   string `json:"distance"`
-	Time       string `json:"time"`
+	ObjectType  string `json:"docType"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayname"`
+	Location    string `json:"location"`
+	Price       int    `json:"price"`
+	//distance in km and time in hours the shipper needs for a delivery
+	Distance int `json:"distance"`
+	Time     int `json:"time"`
+}
+
+//deliveries written before distance and time became numbers keep them as strings
+func (delivery *Delivery) UnmarshalJSON(data []byte) error {
+	type plainDelivery Delivery
+	record := struct {
+		*plainDelivery
+		Distance json.RawMessage `json:"distance"`
+		Time     json.RawMessage `json:"time"`
+	}{plainDelivery: (*plainDelivery)(delivery)}
+	err := json.Unmarshal(data, &record)
+	if err != nil {
+		return err
+	}
+
+	delivery.Distance, err = decodeNumber(record.Distance)
+	if err != nil {
+		return fmt.Errorf("distance of delivery %s: %s", delivery.Name, err.Error())
+	}
+	delivery.Time, err = decodeNumber(record.Time)
+	if err != nil {
+		return fmt.Errorf("time of delivery %s: %s", delivery.Name, err.Error())
+	}
+	return nil
+}
+
+//number stored either as json number or as string, missing or empty is 0
+func decodeNumber(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	number := 0
+	if json.Unmarshal(raw, &number) == nil {
+		return number, nil
+	}
+	text := ""
+	err := json.Unmarshal(raw, &text)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number", string(raw))
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		return 0, nil
+	}
+	number, err = strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number", text)
+	}
+	return number, nil
+}
+
+type DeliveryQuote struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayname"`
+	Location    string `json:"location"`
+	Fee         int    `json:"fee"`
+	Distance    int    `json:"distance"`
+	Time        int    `json:"time"`
 }
 
 type VerifyShipper struct {
 	ObjectType string `json:"docType"`
-	OrderID    string `json:"orderid`
+	OrderID    string `json:"orderid"`
 	Hash       string `json:"hash"`
 	Status     string `json:"status"`
 	Location   string `json:"location"`
 }
 
+type OrderDetails struct {
+	OrderID       string          `json:"orderid"`
+	Order         *Order          `json:"order,omitempty"`
+	OrderHash     *OrderHash      `json:"orderhash,omitempty"`
+	VerifyShipper []VerifyShipper `json:"verifyshipper,omitempty"`
+	LimitTime     *LimitTime      `json:"limittime,omitempty"`
+}
+
+type SellerReport struct {
+	Seller    string `json:"seller"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Orders    int    `json:"orders"`
+	Delivered int    `json:"delivered"`
+	Returned  int    `json:"returned"`
+	Cancelled int    `json:"cancelled"`
+	Revenue   int    `json:"revenue"`
+	Balance   int    `json:"balance"`
+}
+
+type ShipperReport struct {
+	Shipper             string `json:"shipper"`
+	From                string `json:"from"`
+	To                  string `json:"to"`
+	Delivered           int    `json:"delivered"`
+	Refused             int    `json:"refused"`
+	Cancelled           int    `json:"cancelled"`
+	FailedVerifications int    `json:"failedverifications"`
+	CollectedCOD        int    `json:"collectedcod"`
+	FeesEarned          int    `json:"feesearned"`
+	HeldCollateral      int    `json:"heldcollateral"`
+	OpenOrders          int    `json:"openorders"`
+}
+
+//response of the typed getters, redacted lists fields hidden from the caller
+type Record struct {
+	DocType  string      `json:"docType"`
+	Key      string      `json:"key"`
+	Redacted []string    `json:"redacted,omitempty"`
+	Data     interface{} `json:"data"`
+}
+
 type LimitTime struct {
 	ObjectType string `json:"docType"`
 	OrderID    string `json:"orderid"`
@@ -89,6 +280,171 @@ type LimitTime struct {
 	Day        string `json:"day"`
 }
 
+//order lifecycle
+const (
+	StatusCreated   = "Created"
+	StatusAccepted  = "Accepted"
+	StatusPickedUp  = "PickedUp"
+	StatusInTransit = "InTransit"
+	StatusDelivered = "Delivered"
+	StatusSettled   = "Settled"
+	StatusCancelled = "Cancelled"
+	StatusReturning = "Returning"
+	StatusReturned  = "Returned"
+	StatusFailed    = "Failed"
+)
+
+//balance collection of each org until setBalanceCollection changes them
+var defaultBalanceCollections = map[string]string{
+	"Org1MSP": "balanceOrg1Collection",
+	"Org2MSP": "balanceOrg2Collection",
+}
+
+//transfer between orgs
+const (
+	TransferPending   = "Pending"
+	TransferClaimed   = "Claimed"
+	TransferCancelled = "Cancelled"
+)
+
+//statuses an order may move to from its current status, statuses without entry are final
+//once the parcel is picked up it can only fail by going back to the seller through Returning
+var orderTransitions = map[string][]string{
+	StatusCreated:   {StatusAccepted, StatusCancelled, StatusFailed},
+	StatusAccepted:  {StatusPickedUp, StatusCancelled, StatusFailed},
+	StatusPickedUp:  {StatusInTransit, StatusReturning},
+	StatusInTransit: {StatusDelivered, StatusReturning},
+	StatusReturning: {StatusReturned},
+	StatusDelivered: {StatusSettled},
+	StatusReturned:  {StatusSettled},
+}
+
+//reason codes accepted when an order is cancelled
+var cancelReasons = map[string]bool{
+	"CHANGED_MIND":  true,
+	"DUPLICATE":     true,
+	"OUT_OF_STOCK":  true,
+	"WRONG_ADDRESS": true,
+	"PRICE_DISPUTE": true,
+	"LATE_DELIVERY": true,
+	"OTHER":         true,
+}
+
+//time a seller has to confirm a return before the shipper gets its collateral back
+const returnTimeout = 7 * 24 * time.Hour
+
+//largest page a list query returns, keeps query execution time bounded
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	//shippers of a route quoteDelivery reads and ranks before paging
+	maxQuoteCandidates = 1000
+	//rows a page reads, records left out by a filter count too
+	maxScannedRows = 1000
+)
+
+type QueryPage struct {
+	Records             []json.RawMessage `json:"records"`
+	FetchedRecordsCount int               `json:"fetchedrecordscount"`
+	Bookmark            string            `json:"bookmark"`
+}
+
+//orgs of shippers, they must not see how to contact customers
+var shipperOrgs = map[string]bool{
+	"Org2MSP": true,
+}
+
+//collections exportCollection may dump, read access is still decided by each collection's policy
+var exportCollections = map[string]bool{
+	"assetCollection":         true,
+	"assetHashCollection":     true,
+	"orderCollection":         true,
+	"customerCollection":      true,
+	"balanceOrg1Collection":   true,
+	"balanceOrg2Collection":   true,
+	"deliveryCollection":      true,
+	"mortgageCollection":      true,
+	"verifyShipperCollection": true,
+	"limitTimeCollection":     true,
+}
+
+//field of the transient map holding the private input of a function
+const transientKey = "input"
+
+//roles a caller can hold
+const (
+	RoleCustomer = "customer"
+	RoleSeller   = "seller"
+	RoleShipper  = "shipper"
+	RoleAdmin    = "admin"
+)
+
+//certificate attribute holding the caller's roles, comma separated when there are several
+const roleAttribute = "cod.role"
+
+var allRoles = []string{RoleCustomer, RoleSeller, RoleShipper, RoleAdmin}
+
+//orgs whose members may hold each role, so the CA of one org cannot enroll roles of the other
+//an admin only acts for participants of its own org
+var roleOrgs = map[string]map[string]bool{
+	RoleCustomer: {"Org1MSP": true},
+	RoleSeller:   {"Org1MSP": true},
+	RoleShipper:  {"Org2MSP": true},
+	RoleAdmin:    {"Org1MSP": true, "Org2MSP": true},
+}
+
+//roles allowed to call each function, a function missing here cannot be called
+var functionRoles = map[string][]string{
+	"encrypAsset":           {RoleSeller, RoleAdmin},
+	"createAsset":           {RoleSeller, RoleAdmin},
+	"createAssetHash":       {RoleSeller},
+	"createBalance":         {RoleAdmin},
+	"createCustomer":        {RoleCustomer, RoleAdmin},
+	"createDelivery":        {RoleShipper, RoleAdmin},
+	"confirmDelivery":       {RoleCustomer, RoleShipper},
+	"createOrder":           {RoleCustomer, RoleAdmin},
+	"dealLimitTime":         {RoleSeller},
+	"delete":                {RoleAdmin},
+	"acceptOrder":           {RoleShipper},
+	"pickUpOrder":           {RoleShipper},
+	"transitOrder":          {RoleShipper},
+	"cancelOrder":           {RoleCustomer, RoleSeller},
+	"refuseDelivery":        {RoleShipper},
+	"confirmReturn":         {RoleSeller},
+	"settleReturn":          {RoleSeller, RoleShipper, RoleAdmin},
+	"setReturnFee":          {RoleAdmin},
+	"failOrder":             {RoleShipper, RoleAdmin},
+	"query":                 {RoleAdmin},
+	"getCustomer":           allRoles,
+	"getOrder":              allRoles,
+	"getAsset":              allRoles,
+	"getDelivery":           allRoles,
+	"getBalance":            allRoles,
+	"restockAsset":          {RoleSeller, RoleAdmin},
+	"updateAsset":           {RoleSeller, RoleAdmin},
+	"delistAsset":           {RoleSeller, RoleAdmin},
+	"listAssets":            allRoles,
+	"listDeliveries":        allRoles,
+	"exportCollection":      {RoleAdmin},
+	"findDeliveries":        allRoles,
+	"quoteDelivery":         allRoles,
+	"queryCollateral":       {RoleShipper, RoleAdmin},
+	"getOrderHistory":       allRoles,
+	"getOrderDetails":       allRoles,
+	"sellerReport":          {RoleSeller, RoleAdmin},
+	"shipperReport":         {RoleShipper, RoleAdmin},
+	"queryOrdersByCustomer": allRoles,
+	"queryOrdersBySeller":   allRoles,
+	"queryOrdersByShipper":  allRoles,
+	"queryOrdersByStatus":   allRoles,
+	"transferMoney":         allRoles,
+	"initiateTransfer":      allRoles,
+	"claimTransfer":         allRoles,
+	"cancelTransfer":        allRoles,
+	"setBalanceCollection":  {RoleAdmin},
+	"verifyShipper":         {RoleShipper},
+}
+
 /*main*/
 func main() {
 	err := shim.Start(new(COD_chaincode))
@@ -99,6 +455,24 @@ func main() {
 
 // Init chaincode
 func (t *COD_chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+
+	//optional return fee paid by seller when a parcel comes back
+	if len(args) > 0 && len(args[0]) > 0 {
+		returnFee, err := strconv.Atoi(args[0])
+		if err != nil || returnFee < 0 {
+			return shim.Error("return fee must be a positive number")
+		}
+		config, err := getConfig(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		config.ReturnFee = returnFee
+		err = putConfig(stub, &config)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 	return shim.Success(nil)
 }
 
@@ -107,6 +481,11 @@ func (t *COD_chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Printf("invoke is running" + function)
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	switch function {
 	case "encrypAsset":
 		return t.encrypAsset(stub, args)
@@ -120,18 +499,90 @@ func (t *COD_chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.createCustomer(stub, args)
 	case "createDelivery":
 		return t.createDelivery(stub, args)
+	case "confirmDelivery":
+		return t.confirmDelivery(stub, args)
 	case "createOrder":
 		return t.createOrder(stub, args)
 	case "dealLimitTime":
 		return t.dealLimitTime(stub, args)
 	case "delete":
 		return t.delete(stub, args)
+	case "acceptOrder":
+		return t.acceptOrder(stub, args)
+	case "pickUpOrder":
+		return t.updateOrderStatus(stub, args, function, StatusPickedUp)
+	case "transitOrder":
+		return t.updateOrderStatus(stub, args, function, StatusInTransit)
+	case "cancelOrder":
+		return t.cancelOrder(stub, args)
+	case "refuseDelivery":
+		return t.refuseDelivery(stub, args)
+	case "confirmReturn":
+		return t.confirmReturn(stub, args)
+	case "settleReturn":
+		return t.settleReturn(stub, args)
+	case "setReturnFee":
+		return t.setReturnFee(stub, args)
+	case "failOrder":
+		return t.updateOrderStatus(stub, args, function, StatusFailed)
 	// case "imageToByte":
 	// 	return t.imageToByte(stub, args)
 	case "query":
 		return t.query(stub, args)
+	case "getCustomer":
+		return t.getCustomer(stub, args)
+	case "getOrder":
+		return t.getOrder(stub, args)
+	case "getAsset":
+		return t.getAsset(stub, args)
+	case "getDelivery":
+		return t.getDelivery(stub, args)
+	case "getBalance":
+		return t.getBalance(stub, args)
+	case "restockAsset":
+		return t.restockAsset(stub, args)
+	case "updateAsset":
+		return t.updateAsset(stub, args)
+	case "delistAsset":
+		return t.delistAsset(stub, args)
+	case "listAssets":
+		return t.listAssets(stub, args)
+	case "listDeliveries":
+		return t.listDeliveries(stub, args)
+	case "exportCollection":
+		return t.exportCollection(stub, args)
+	case "findDeliveries":
+		return t.findDeliveries(stub, args)
+	case "quoteDelivery":
+		return t.quoteDelivery(stub, args)
+	case "queryCollateral":
+		return t.queryCollateral(stub, args)
+	case "getOrderHistory":
+		return t.getOrderHistory(stub, args)
+	case "getOrderDetails":
+		return t.getOrderDetails(stub, args)
+	case "sellerReport":
+		return t.sellerReport(stub, args)
+	case "shipperReport":
+		return t.shipperReport(stub, args)
+	case "queryOrdersByCustomer":
+		return t.queryOrdersBy(stub, args, function, "customer")
+	case "queryOrdersBySeller":
+		return t.queryOrdersBy(stub, args, function, "seller")
+	case "queryOrdersByShipper":
+		return t.queryOrdersBy(stub, args, function, "delivery")
+	case "queryOrdersByStatus":
+		return t.queryOrdersBy(stub, args, function, "status")
 	case "transferMoney":
 		return t.transferMoney(stub, args)
+	case "initiateTransfer":
+		return t.initiateTransfer(stub, args)
+	case "claimTransfer":
+		return t.claimTransfer(stub, args)
+	case "cancelTransfer":
+		return t.cancelTransfer(stub, args)
+	case "setBalanceCollection":
+		return t.setBalanceCollection(stub, args)
 	case "verifyShipper":
 		return t.verifyShipper(stub, args)
 
@@ -141,14 +592,20 @@ func (t *COD_chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	}
 }
 
-//create customer information
+//create customer information under the caller's identity, args: identity (optional, admins only)
+//transient input: name, location, number, email
 func (t *COD_chaincode) createCustomer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("\n=============== start createCustomer function ===============")
 	start := time.Now()
 	time.Sleep(time.Second)
-	if len(args) != 4 {
-		return shim.Error("expecting 4 argument")
+	if len(args) > 1 {
+		return shim.Error("expecting at most 1 argument, identity, the customer is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "name", "location", "number", "email")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
 	}
+	args = append(input, args...)
 
 	if len(args[0]) == 0 {
 		return shim.Error("Customer's name must be declare")
@@ -163,6 +620,10 @@ func (t *COD_chaincode) createCustomer(stub shim.ChaincodeStubInterface, args []
 		return shim.Error("Customer's email must be declare")
 	}
 
+	id, err := getParticipantID(stub, args, 4)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	name := args[0]
 	location := args[1]
 	number := args[2]
@@ -170,14 +631,18 @@ func (t *COD_chaincode) createCustomer(stub shim.ChaincodeStubInterface, args []
 
 	//convert variable to json
 	objectType := "Customer"
-	customer := &Customer{objectType, name, location, number, email}
+	customer := &Customer{objectType, id, name, location, number, email}
 	customer_to_byte, err := json.Marshal(customer)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	//save to database
-	err = stub.PutPrivateData("customerCollection", name, customer_to_byte)
+	err = checkNotExist(stub, "customerCollection", id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutPrivateData("customerCollection", id, customer_to_byte)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -202,14 +667,20 @@ func (t *COD_chaincode) createCustomer(stub shim.ChaincodeStubInterface, args []
 	return shim.Success(nil)
 }
 
-//create asset
+//create asset of the caller, args: identity of seller (optional, admins only)
+//transient input: sellername, asset, quantity, price
 func (t *COD_chaincode) createAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("\n=============== start createAsset function ===============")
 	start := time.Now()
 	time.Sleep(time.Second)
-	if len(args) != 4 {
-		return shim.Error("there must be 4 argument")
+	if len(args) > 1 {
+		return shim.Error("expecting at most 1 argument, identity of seller, the asset is passed in the transient input")
 	}
+	input, errInput := getTransientArgs(stub, "sellername", "asset", "quantity", "price")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(input, args...)
 
 	if len(args[0]) == 0 {
 		return shim.Error("name of seller must be declare")
@@ -220,11 +691,18 @@ func (t *COD_chaincode) createAsset(stub shim.ChaincodeStubInterface, args []str
 	if len(args[2]) == 0 {
 		return shim.Error("quantity must be declare")
 	}
-	if len(args[0]) == 0 {
+	if len(args[3]) == 0 {
 		return shim.Error("price must be declare")
 	}
+	if strings.Contains(args[1], "/") {
+		return shim.Error("name of asset must not contain /")
+	}
+	name, err := getParticipantID(stub, args, 4)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	name := args[0]
+	sellerName := args[0]
 	asset := args[1]
 	quantity, q_err := strconv.Atoi(args[2])
 	price, p_err := strconv.Atoi(args[3])
@@ -235,17 +713,27 @@ func (t *COD_chaincode) createAsset(stub shim.ChaincodeStubInterface, args []str
 	if p_err != nil {
 		return shim.Error("price must be a number")
 	}
+	if quantity < 0 {
+		return shim.Error("quantity must not be negative")
+	}
+	if price < 0 {
+		return shim.Error("price must not be negative")
+	}
 
 	//convert variable to json
 	objectType := "Seller"
-	seller := &Asset{objectType, name, asset, quantity, price}
+	seller := &Asset{objectType, name, sellerName, asset, quantity, price, 0}
 	seller_to_byte, err := json.Marshal(seller)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	//save to database
-	err = stub.PutPrivateData("assetCollection", name, seller_to_byte)
+	//save to database, a seller can have many assets
+	err = checkNotExist(stub, "assetCollection", assetKey(name, asset))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutPrivateData("assetCollection", assetKey(name, asset), seller_to_byte)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -272,331 +760,2818 @@ func (t *COD_chaincode) createAsset(stub shim.ChaincodeStubInterface, args []str
 	return shim.Success(nil)
 }
 
-//query data
-func (t *COD_chaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("\n=============== start query function ===============")
+//seller adds or removes stock of an asset, args: seller, asset, transient input: quantity to add (negative to remove)
+func (t *COD_chaincode) restockAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start restockAsset function ===============")
 	start := time.Now()
-	time.Sleep(time.Second)
-	var name, jsonResp string
-	var err error
-
 	if len(args) != 2 {
-		return shim.Error("index of object is invalid")
-	}
-
-	name = args[0]
-	valAsBytes, err := stub.GetPrivateData(args[1], name)
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + ": " + err.Error() + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsBytes == nil {
-		jsonResp = "{\"Error\":\"object does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return shim.Error("expecting 2 argument, seller and asset, the quantity is passed in the transient input")
 	}
-
-	end := time.Now()
-	elapsed := time.Since(start)
-	fmt.Println("function query")
-	fmt.Println("time start: ", start.String())
-	fmt.Println("time end: ", end.String())
-	fmt.Println("time execute: ", elapsed.String())
-	printMemUsage()
-	fmt.Println("\n=============== end query function ===============")
-
-	return shim.Success(valAsBytes)
-}
-
-//create delivery information
-func (t *COD_chaincode) createBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("\n=============== start createBalance function ===============")
-	start := time.Now()
-	time.Sleep(time.Second)
-
-	if len(args) != 3 {
-		return shim.Error("expecting 3 argument, name and balance")
-		// return "expecting 3 argument, name and balance"
+	input, errInput := getTransientArgs(stub, "quantity")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
 	}
+	args = append(args, input...)
 
-	name := args[0]
-	balance, err_owner_balance := strconv.Atoi(args[1])
-	if err_owner_balance != nil {
-		return shim.Error("balance must be a number")
-		// return "Error"
-	}
-	collection := ""
-	switch args[2] {
-	case "Org1":
-		collection = "balanceOrg1Collection"
-	case "Org2":
-		collection = "balanceOrg2Collection"
-	case "mortgage":
-		collection = "mortgageCollection"
+	err := checkOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	//convert to json
-	objectType := "Balance"
-	owner := &Balance{objectType, name, balance}
-	owner_to_byte, err := json.Marshal(owner)
+	change, err := strconv.Atoi(args[2])
 	if err != nil {
-		return shim.Error(err.Error())
-		// return "Error"
+		return shim.Error("quantity must be a number")
 	}
 
-	//save to ledger
-	err = stub.PutPrivateData(collection, name, owner_to_byte)
+	asset, err := getAsset(stub, args[0], args[1])
 	if err != nil {
 		return shim.Error(err.Error())
-		// return "Error"
 	}
+	if asset.Quantity+change < asset.Reserved {
+		return shim.Error("quantity cannot be less than reserved quantity " + strconv.Itoa(asset.Reserved))
+	}
+	asset.Quantity = asset.Quantity + change
 
-	//create and save key
-	indexName := "name~balance"
-	balanceNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{owner.Name, strconv.Itoa(owner.Balance)})
+	err = putAsset(stub, &asset)
 	if err != nil {
 		return shim.Error(err.Error())
-		// return " Error"
 	}
-	value := []byte{0x00}
-	stub.PutPrivateData(collection, balanceNameIndexKey, value)
 
 	end := time.Now()
 	elapsed := time.Since(start)
-
-	fmt.Println("\nfunction createBalance")
+	fmt.Println("\nfunction restockAsset")
 	fmt.Println("time start: ", start.String())
 	fmt.Println("time end: ", end.String())
 	fmt.Println("time execute: ", elapsed.String())
 	printMemUsage()
-	fmt.Println("\n=============== end createBalance function ===============")
-
+	fmt.Println("\n=============== end restockAsset function ===============")
 	return shim.Success(nil)
 }
 
-func (t *COD_chaincode) createDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("\n=============== start createDelivery function ===============")
+//seller changes quantity and price of an asset, args: seller, asset, transient input: quantity, price
+func (t *COD_chaincode) updateAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start updateAsset function ===============")
 	start := time.Now()
-	time.Sleep(time.Second)
-
-	//check length of data
-	if len(args) != 5 {
-		return shim.Error("expecting 5 argument")
+	if len(args) != 2 {
+		return shim.Error("expecting 2 argument, seller and asset, quantity and price are passed in the transient input")
 	}
-
-	//definite data variable
-	name := args[0]
-	location := args[1]
-	price, errPrice := strconv.Atoi(args[2])
-	if errPrice != nil {
-		return shim.Error("prive must be a number")
+	input, errInput := getTransientArgs(stub, "quantity", "price")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
 	}
-	distance := args[3]
-	Dtime := args[4]
-	ObjectType := "Delivery"
+	args = append(args, input...)
 
-	delivery := &Delivery{ObjectType, name, location, price, distance, Dtime}
-
-	//marshal delivery to byte
-	deliveryAsByte, errDelivery := json.Marshal(delivery)
-	if errDelivery != nil {
-		return shim.Error("cannot marshal delivery's data")
+	err := checkOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	//put data to ledger
-	errDelivery = stub.PutPrivateData("deliveryCollection", name, deliveryAsByte)
-	if errDelivery != nil {
-		return shim.Error("cannot put private data of delivery")
+	quantity, q_err := strconv.Atoi(args[2])
+	price, p_err := strconv.Atoi(args[3])
+	if q_err != nil {
+		return shim.Error("quantity must be a number")
+	}
+	if p_err != nil {
+		return shim.Error("price must be a number")
+	}
+	if quantity < 0 {
+		return shim.Error("quantity must not be negative")
+	}
+	if price < 0 {
+		return shim.Error("price must not be negative")
 	}
 
-	//create index key
-	indexKey := "name"
-	deliveryIndexKey, errDeliveryIndexKey := stub.CreateCompositeKey(indexKey, []string{delivery.Name, delivery.Location, strconv.Itoa(delivery.Price), delivery.Distance, delivery.Time})
-	if errDeliveryIndexKey != nil {
-		return shim.Error("cannot create index key of delivery")
+	asset, err := getAsset(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	if quantity < asset.Reserved {
+		return shim.Error("quantity cannot be less than reserved quantity " + strconv.Itoa(asset.Reserved))
+	}
+	asset.Quantity = quantity
+	asset.Price = price
 
-	//save key
-	value := []byte{0x00}
-	stub.PutPrivateData("deliveryCollection", deliveryIndexKey, value)
+	err = putAsset(stub, &asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	end := time.Now()
 	elapsed := time.Since(start)
-
-	fmt.Println("\nfunction createBalance")
+	fmt.Println("\nfunction updateAsset")
 	fmt.Println("time start: ", start.String())
 	fmt.Println("time end: ", end.String())
 	fmt.Println("time execute: ", elapsed.String())
 	printMemUsage()
-	fmt.Println("\n=============== end createDelivery function ===============")
-	time.Sleep(time.Second)
-
+	fmt.Println("\n=============== end updateAsset function ===============")
 	return shim.Success(nil)
 }
 
-//transfer money to new owner
-func (t *COD_chaincode) transferMoney(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("\n=============== start transferMoney function ===============")
+//seller stops selling an asset, args: seller, asset
+func (t *COD_chaincode) delistAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start delistAsset function ===============")
 	start := time.Now()
-	time.Sleep(time.Second)
-	if len(args) != 5 {
-		return shim.Error("expecting 5 arguments")
-	}
-
-	old_owner := Balance{}
-	new_owner := Balance{}
-	old_owner_name := args[0]
-	mortgage, err_mortgage := strconv.Atoi(args[1])
-	if err_mortgage != nil {
-		return shim.Error("balance isn't a number")
+	if len(args) != 2 {
+		return shim.Error("there must be 2 argument, seller and asset")
 	}
-	new_owner_name := args[2]
-	collection1 := args[3]
-	collection2 := args[4]
 
-	//get old owner's information
-	old_owner_as_byte, err := stub.GetPrivateData(collection1, old_owner_name)
+	err := checkOwner(stub, args[0])
 	if err != nil {
-		return shim.Error("cannot get owner's infor")
-	} else if old_owner_as_byte == nil {
-		return shim.Error("owner doesn't exist")
+		return shim.Error(err.Error())
 	}
 
-	//unmarshal to old_owner variable
-	err = json.Unmarshal(old_owner_as_byte, &old_owner)
+	asset, err := getAsset(stub, args[0], args[1])
 	if err != nil {
-		return shim.Error("cannot unmarshal data")
+		return shim.Error(err.Error())
 	}
 
-	//check old_owner balance
-	if old_owner.Balance < mortgage {
-		return shim.Error("present owner does not enough balance")
+	//open orders still wait for these goods
+	if asset.Reserved > 0 {
+		return shim.Error("asset " + asset.Asset + " is reserved by open orders")
 	}
-	old_owner.Balance = old_owner.Balance - mortgage
-
-	new_info_old_owner_as_byte, err := json.Marshal(old_owner)
 
-	err = stub.PutPrivateData(collection2, old_owner_name, new_info_old_owner_as_byte)
+	err = stub.DelPrivateData("assetCollection", assetKey(asset.Name, asset.Asset))
 	if err != nil {
-		return shim.Error("cannot save new info of old owner")
+		return shim.Error("cannot delete asset")
 	}
 
-	//get info of virtual account
-	new_owner_as_byte, err := stub.GetPrivateData(collection2, new_owner_name)
-	if err != nil {
-		return shim.Error("cannot get info of new owner")
-	}
-	err = json.Unmarshal(new_owner_as_byte, &new_owner)
-	if err != nil {
-		return shim.Error("cannot unmarshal new owner")
-	}
-	new_owner.Balance = new_owner.Balance + mortgage
-	new_owner_new_info_as_byte, err := json.Marshal(new_owner)
+	indexName := "name~asset"
+	assetNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{asset.Name, asset.Asset})
 	if err != nil {
-		return shim.Error("cannot marshal new info of new owner")
+		return shim.Error(err.Error())
 	}
-	err = stub.PutPrivateData(collection2, new_owner_name, new_owner_new_info_as_byte)
+	err = stub.DelPrivateData("assetCollection", assetNameIndexKey)
 	if err != nil {
-		return shim.Error("cannot put new data of new owner")
+		return shim.Error("cannot delete key")
 	}
 
 	end := time.Now()
 	elapsed := time.Since(start)
-	fmt.Println("\nfunction transferMoney")
+	fmt.Println("\nfunction delistAsset")
 	fmt.Println("time start: ", start.String())
 	fmt.Println("time end: ", end.String())
 	fmt.Println("time execute: ", elapsed.String())
 	printMemUsage()
-	fmt.Println("\n=============== end transferMoney function ===============")
+	fmt.Println("\n=============== end delistAsset function ===============")
 	return shim.Success(nil)
 }
 
-//create order information
-func (t *COD_chaincode) createOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("\n=============== start createOrder function ===============")
+//list assets of a seller through the name~asset index, args: seller, page size (optional), bookmark (optional)
+func (t *COD_chaincode) listAssets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start listAssets function ===============")
 	start := time.Now()
-	time.Sleep(time.Second)
-	if len(args) != 9 {
-		return shim.Error("expecting 9 argument")
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("expecting 1 to 3 argument, name of seller, page size and bookmark")
+	}
+	pageSize, bookmark, err := getPageArgs(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey("assetCollection", "name~asset", []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	//index entries only carry the key, the asset is read from its own record
+	page, err := readPage(resultsIterator, pageSize, bookmark, func(key string, value []byte) ([]byte, error) {
+		_, keyParts, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return stub.GetPrivateData("assetCollection", assetKey(keyParts[0], keyParts[1]))
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	assetsAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction listAssets")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end listAssets function ===============")
+	return shim.Success(assetsAsByte)
+}
+
+//query data
+func (t *COD_chaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start query function ===============")
+	start := time.Now()
+	time.Sleep(time.Second)
+	var name, jsonResp string
+	var err error
+
+	if len(args) != 2 {
+		return shim.Error("index of object is invalid")
+	}
+
+	name = args[0]
+	valAsBytes, err := stub.GetPrivateData(args[1], name)
+	if err != nil {
+		jsonResp = "{\"Error\":\"Failed to get state for " + name + ": " + err.Error() + "\"}"
+		return shim.Error(jsonResp)
+	} else if valAsBytes == nil {
+		jsonResp = "{\"Error\":\"object does not exist: " + name + "\"}"
+		return shim.Error(jsonResp)
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("function query")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end query function ===============")
+
+	return shim.Success(valAsBytes)
+}
+
+//get customer, contact fields are redacted for shipper orgs and shippers, args: identity of customer
+func (t *COD_chaincode) getCustomer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, name of customer")
+	}
+
+	customer := Customer{}
+	err := readRecord(stub, "customerCollection", args[0], "Customer", &customer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	record := Record{"Customer", args[0], nil, &customer}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("cannot get caller's msp id")
+	}
+	roles, err := getRoles(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if shipperOrgs[mspID] || (hasRole(roles, RoleShipper) && !hasRole(roles, RoleAdmin)) {
+		customer.Number = ""
+		customer.Email = ""
+		record.Redacted = []string{"number", "email"}
+	}
+	return recordResponse(record)
+}
+
+//get order of the caller, args: order id
+func (t *COD_chaincode) getOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id")
+	}
+
+	order := Order{}
+	err := readRecord(stub, "orderCollection", args[0], "Order", &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Customer, order.Seller, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return recordResponse(Record{"Order", args[0], nil, &order})
+}
+
+//get asset of seller, args: seller, asset
+func (t *COD_chaincode) getAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("expecting 2 argument, seller and asset")
+	}
+
+	asset := Asset{}
+	err := readRecord(stub, "assetCollection", assetKey(args[0], args[1]), "Seller", &asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return recordResponse(Record{"Seller", assetKey(args[0], args[1]), nil, &asset})
+}
+
+//get delivery, args: identity of shipper
+func (t *COD_chaincode) getDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, name of shipper")
+	}
+
+	delivery := Delivery{}
+	err := readRecord(stub, "deliveryCollection", args[0], "Delivery", &delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return recordResponse(Record{"Delivery", args[0], nil, &delivery})
+}
+
+//get balance, args: identity of owner, balance or mortgage
+func (t *COD_chaincode) getBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("expecting 2 argument, identity and balance or mortgage")
+	}
+	err := checkOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	collection, err := balanceCollection(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	balance := Balance{}
+	err = readRecord(stub, collection, args[0], "Balance", &balance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return recordResponse(Record{"Balance", args[0], nil, &balance})
+}
+
+//read a document and make sure it is of the expected docType, composite index keys are never documents
+func readRecord(stub shim.ChaincodeStubInterface, collection string, key string, docType string, v interface{}) error {
+	valAsBytes, err := stub.GetPrivateData(collection, key)
+	if err != nil {
+		return fmt.Errorf("cannot get %s: %s", key, err.Error())
+	} else if valAsBytes == nil {
+		return fmt.Errorf("%s does not exist: %s", docType, key)
+	}
+
+	header := struct {
+		ObjectType string `json:"docType"`
+	}{}
+	err = json.Unmarshal(valAsBytes, &header)
+	if err != nil || header.ObjectType != docType {
+		return fmt.Errorf("%s is not a %s", key, docType)
+	}
+
+	err = json.Unmarshal(valAsBytes, v)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal %s", key)
+	}
+	return nil
+}
+
+func recordResponse(record Record) pb.Response {
+	recordAsByte, err := json.Marshal(record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(recordAsByte)
+}
+
+//collection of a balance, the org's own for balance and the shared one for mortgage
+func balanceCollection(stub shim.ChaincodeStubInterface, id string, kind string) (string, error) {
+	switch kind {
+	case "balance":
+		return orgBalanceCollection(stub, id)
+	case "mortgage":
+		return "mortgageCollection", nil
+	}
+	return "", fmt.Errorf("balance must be balance or mortgage, not %s", kind)
+}
+
+//balance collection of the org a participant identity belongs to, orgs without one are rejected
+func orgBalanceCollection(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	config, err := getConfig(stub)
+	if err != nil {
+		return "", err
+	}
+	mspID := strings.SplitN(id, "/", 2)[0]
+	collection, ok := config.BalanceCollections[mspID]
+	if !ok {
+		return "", fmt.Errorf("org %s has no balance collection", mspID)
+	}
+	return collection, nil
+}
+
+//create balance in the owner's org collection or mortgage, args: identity of owner (optional, admins only)
+//transient input: name, balance, kind (balance or mortgage)
+func (t *COD_chaincode) createBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start createBalance function ===============")
+	start := time.Now()
+	time.Sleep(time.Second)
+
+	if len(args) > 1 {
+		return shim.Error("expecting at most 1 argument, identity, the balance is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "name", "balance", "kind")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(input, args...)
+
+	name, err := getParticipantID(stub, args, 3)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	displayName := args[0]
+	balance, err_owner_balance := strconv.Atoi(args[1])
+	if err_owner_balance != nil {
+		return shim.Error("balance must be a number")
+		// return "Error"
+	}
+	collection, err := balanceCollection(stub, name, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//convert to json
+	objectType := "Balance"
+	owner := &Balance{objectType, name, displayName, balance, 0, nil}
+	owner_to_byte, err := json.Marshal(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+		// return "Error"
+	}
+
+	//save to ledger
+	err = checkNotExist(stub, collection, name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutPrivateData(collection, name, owner_to_byte)
+	if err != nil {
+		return shim.Error(err.Error())
+		// return "Error"
+	}
+
+	//create and save key
+	indexName := "name~balance"
+	balanceNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{owner.Name, strconv.Itoa(owner.Balance)})
+	if err != nil {
+		return shim.Error(err.Error())
+		// return " Error"
+	}
+	value := []byte{0x00}
+	stub.PutPrivateData(collection, balanceNameIndexKey, value)
+
+	end := time.Now()
+	elapsed := time.Since(start)
+
+	fmt.Println("\nfunction createBalance")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end createBalance function ===============")
+
+	return shim.Success(nil)
+}
+
+//create delivery of the caller, args: identity of shipper (optional, admins only)
+//transient input: name, location, price, distance, time
+func (t *COD_chaincode) createDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start createDelivery function ===============")
+	start := time.Now()
+	time.Sleep(time.Second)
+
+	//check length of data
+	if len(args) > 1 {
+		return shim.Error("expecting at most 1 argument, identity, the delivery is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "name", "location", "price", "distance", "time")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(input, args...)
+
+	//definite data variable
+	name, err := getParticipantID(stub, args, 5)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	displayName := args[0]
+	location := args[1]
+	price, errPrice := strconv.Atoi(args[2])
+	if errPrice != nil {
+		return shim.Error("prive must be a number")
+	}
+	distance, errDistance := strconv.Atoi(args[3])
+	if errDistance != nil {
+		return shim.Error("distance must be a number of km")
+	}
+	Dtime, errTime := strconv.Atoi(args[4])
+	if errTime != nil {
+		return shim.Error("time must be a number of hours")
+	}
+	//a negative price would lower the total of an order and turn its fees around
+	if price < 0 {
+		return shim.Error("price must not be negative")
+	}
+	if distance < 0 || Dtime < 0 {
+		return shim.Error("distance and time must not be negative")
+	}
+	ObjectType := "Delivery"
+
+	delivery := &Delivery{ObjectType, name, displayName, location, price, distance, Dtime}
+
+	//marshal delivery to byte
+	deliveryAsByte, errDelivery := json.Marshal(delivery)
+	if errDelivery != nil {
+		return shim.Error("cannot marshal delivery's data")
+	}
+
+	//put data to ledger
+	errDelivery = checkNotExist(stub, "deliveryCollection", name)
+	if errDelivery != nil {
+		return shim.Error(errDelivery.Error())
+	}
+	errDelivery = stub.PutPrivateData("deliveryCollection", name, deliveryAsByte)
+	if errDelivery != nil {
+		return shim.Error("cannot put private data of delivery")
+	}
+
+	//create index key
+	indexKey := "name"
+	deliveryIndexKey, errDeliveryIndexKey := stub.CreateCompositeKey(indexKey, []string{delivery.Name, delivery.Location, strconv.Itoa(delivery.Price), strconv.Itoa(delivery.Distance), strconv.Itoa(delivery.Time)})
+	if errDeliveryIndexKey != nil {
+		return shim.Error("cannot create index key of delivery")
+	}
+
+	//save key
+	value := []byte{0x00}
+	stub.PutPrivateData("deliveryCollection", deliveryIndexKey, value)
+
+	end := time.Now()
+	elapsed := time.Since(start)
+
+	fmt.Println("\nfunction createBalance")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end createDelivery function ===============")
+	time.Sleep(time.Second)
+
+	return shim.Success(nil)
+}
+
+//transfer money of the caller to someone of the same org, args: identity of owner, identity of new owner, order id (optional)
+//transient input: amount
+//money between orgs goes through initiateTransfer and claimTransfer
+func (t *COD_chaincode) transferMoney(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start transferMoney function ===============")
+	start := time.Now()
+	time.Sleep(time.Second)
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("expecting 2 or 3 arguments, owner, new owner and order id, the amount is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "amount")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append([]string{args[0], input[0]}, args[1:]...)
+
+	ownerName := args[0]
+	amount, err := strconv.Atoi(args[1])
+	if err != nil || amount <= 0 {
+		return shim.Error("amount must be a positive number")
+	}
+	newOwnerName := args[2]
+	err = checkOwner(stub, ownerName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//a transfer made for an order is kept in its history, only a party of the order may add to it
+	order := Order{}
+	if len(args) == 4 && len(args[3]) > 0 {
+		order, err = getOrder(stub, args[3])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkOwner(stub, order.Customer, order.Seller, order.Delivery)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	//both balances must live in the same org's collection
+	collection, err := orgBalanceCollection(stub, ownerName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newCollection, err := orgBalanceCollection(stub, newOwnerName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if collection != newCollection {
+		return shim.Error(ownerName + " and " + newOwnerName + " are in different orgs, use initiateTransfer")
+	}
+	if ownerName == newOwnerName {
+		return shim.Error("cannot transfer money from " + ownerName + " to itself")
+	}
+
+	//money held as collateral cannot be moved
+	changes := map[string]int{}
+	changes[ownerName] = changes[ownerName] - amount
+	changes[newOwnerName] = changes[newOwnerName] + amount
+	err = changeBalances(stub, changes, "transfer")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(order.OrderID) > 0 {
+		err = logOrderEvent(stub, &order, "transferMoney", ownerName+" to "+newOwnerName+" "+args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putOrder(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction transferMoney")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end transferMoney function ===============")
+	return shim.Success(nil)
+}
+
+//owner sends money to another org, it leaves the owner's collection and waits in mortgageCollection
+//until the recipient claims it, args: identity of owner, identity of recipient, order id (optional), transient input: amount
+func (t *COD_chaincode) initiateTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start initiateTransfer function ===============")
+	start := time.Now()
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("expecting 2 or 3 arguments, owner, recipient and order id, the amount is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "amount")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append([]string{args[0], input[0]}, args[1:]...)
+
+	ownerName := args[0]
+	amount, err := strconv.Atoi(args[1])
+	if err != nil || amount <= 0 {
+		return shim.Error("amount must be a positive number")
+	}
+	recipient := args[2]
+	orderID := ""
+	if len(args) == 4 {
+		orderID = args[3]
+	}
+	err = checkOwner(stub, ownerName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//only a party of the order may tie a transfer to it
+	order := Order{}
+	if len(orderID) > 0 {
+		order, err = getOrder(stub, orderID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkOwner(stub, order.Customer, order.Seller, order.Delivery)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	collection, err := orgBalanceCollection(stub, ownerName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recipientCollection, err := orgBalanceCollection(stub, recipient)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if collection == recipientCollection {
+		return shim.Error(ownerName + " and " + recipient + " are in the same org, use transferMoney")
+	}
+
+	owner, err := getBalance(stub, collection, ownerName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner.Balance-owner.Held < amount {
+		return shim.Error("present owner does not enough balance")
+	}
+	owner.Balance = owner.Balance - amount
+	err = putBalance(stub, collection, &owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	id, err := newTransfer(stub, ownerName, recipient, amount, orderID, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(orderID) > 0 {
+		err = logOrderEvent(stub, &order, "initiateTransfer", ownerName+" to "+recipient+" "+args[1]+" by transfer "+id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putOrder(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction initiateTransfer")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end initiateTransfer function ===============")
+	return shim.Success([]byte(id))
+}
+
+//recipient takes a pending transfer into its own org's collection, args: transfer id
+func (t *COD_chaincode) claimTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.closeTransfer(stub, args, "claimTransfer", TransferClaimed)
+}
+
+//owner takes back a transfer nobody claimed yet, args: transfer id
+func (t *COD_chaincode) cancelTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.closeTransfer(stub, args, "cancelTransfer", TransferCancelled)
+}
+
+//pay a pending transfer to its recipient when claimed or back to its owner when cancelled
+func (t *COD_chaincode) closeTransfer(stub shim.ChaincodeStubInterface, args []string, function string, status string) pb.Response {
+	fmt.Println("\n=============== start " + function + " function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, transfer id")
+	}
+
+	transfer, err := getTransfer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if transfer.Status != TransferPending {
+		return shim.Error("transfer " + transfer.TransferID + " is already " + transfer.Status)
+	}
+	if transfer.Payment && status == TransferCancelled {
+		return shim.Error("transfer " + transfer.TransferID + " pays order " + transfer.OrderID + " and cannot be cancelled")
+	}
+	payee := transfer.To
+	if status == TransferCancelled {
+		payee = transfer.From
+	}
+	err = checkOwner(stub, payee)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	collection, err := orgBalanceCollection(stub, payee)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balance, err := getBalance(stub, collection, payee)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balance.Balance = balance.Balance + transfer.Amount
+	err = putBalance(stub, collection, &balance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	transfer.UpdatedBy, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	transfer.UpdatedAt, err = getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	transfer.Status = status
+	err = putTransfer(stub, &transfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if len(transfer.OrderID) > 0 {
+		order, err := getOrder(stub, transfer.OrderID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = logOrderEvent(stub, &order, function, "transfer "+transfer.TransferID+" paid to "+payee)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putOrder(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction " + function)
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end " + function + " function ===============")
+	return shim.Success(nil)
+}
+
+//create a pending transfer of money already taken from its owner, the transaction id is its id
+//so a transaction creates at most one transfer
+func newTransfer(stub shim.ChaincodeStubInterface, from string, to string, amount int, orderID string, payment bool) (string, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return "", err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return "", err
+	}
+	id := stub.GetTxID()
+	err = checkNotExist(stub, "mortgageCollection", transferKey(id))
+	if err != nil {
+		return "", err
+	}
+	transfer := &Transfer{"Transfer", id, from, to, amount, orderID, TransferPending, caller, txTime, payment}
+	err = putTransfer(stub, transfer)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+//pay part of an order from the org balance of payer, the money waits in a pending transfer until the payee claims it,
+//so a transaction only touches the balance collection of the payer's org, which is the caller's org
+//nothing moves when payer and payee are the same participant, returns the id of the transfer if any
+func payOrder(stub shim.ChaincodeStubInterface, order *Order, payer string, payee string, amount int, reason string) (string, error) {
+	if amount <= 0 || payer == payee {
+		return "", nil
+	}
+	collection, err := orgBalanceCollection(stub, payer)
+	if err != nil {
+		return "", err
+	}
+	balance, err := getBalance(stub, collection, payer)
+	if err != nil {
+		return "", err
+	}
+	//money held as collateral cannot be paid
+	if balance.Balance-balance.Held < amount {
+		return "", fmt.Errorf("%s does not have enough balance to pay %s", payer, reason)
+	}
+	balance.Balance = balance.Balance - amount
+	err = putBalance(stub, collection, &balance)
+	if err != nil {
+		return "", err
+	}
+	return newTransfer(stub, payer, payee, amount, order.OrderID, true)
+}
+
+func transferKey(id string) string {
+	return "transfer:" + id
+}
+
+//get a transfer from mortgageCollection
+func getTransfer(stub shim.ChaincodeStubInterface, id string) (Transfer, error) {
+	transfer := Transfer{}
+	err := readRecord(stub, "mortgageCollection", transferKey(id), "Transfer", &transfer)
+	return transfer, err
+}
+
+//save a transfer to mortgageCollection
+func putTransfer(stub shim.ChaincodeStubInterface, transfer *Transfer) error {
+	transferAsByte, err := json.Marshal(transfer)
+	if err != nil {
+		return fmt.Errorf("cannot marshal transfer %s", transfer.TransferID)
+	}
+	err = stub.PutPrivateData("mortgageCollection", transferKey(transfer.TransferID), transferAsByte)
+	if err != nil {
+		return fmt.Errorf("cannot put transfer %s: %s", transfer.TransferID, err.Error())
+	}
+	return nil
+}
+
+//create order of the caller, args: identity of customer, identity of seller, identity of shipper
+//transient input: detail, items, amount (optional)
+func (t *COD_chaincode) createOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start createOrder function ===============")
+	start := time.Now()
+	time.Sleep(time.Second)
+	if len(args) != 3 {
+		return shim.Error("expecting 3 argument, customer, seller and delivery, the order is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "detail", "items", "amount")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(args, input...)
+
+	//order id is the transaction id, clients cannot pick or reuse it
+	id := stub.GetTxID()
+	err := checkNotExist(stub, "orderCollection", id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//parties are identities, the customer must be the caller and registered
+	customer := args[0]
+	seller := args[1]
+	delivery := args[2]
+	detail := args[3]
+	err = checkOwner(stub, customer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = readRecord(stub, "customerCollection", customer, "Customer", &Customer{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	items, err := parseOrderItems(args[4])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//every line is priced from the seller's asset, prices sent by the client are only checked
+	price := 0
+	for i := range items {
+		asset, err := getAsset(stub, seller, items[i].Asset)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if items[i].UnitPrice != 0 && items[i].UnitPrice != asset.Price {
+			return shim.Error("unit price of " + items[i].Asset + " must be " + strconv.Itoa(asset.Price))
+		}
+		items[i].UnitPrice = asset.Price
+		total := items[i].Quantity * asset.Price
+		if total <= 0 {
+			return shim.Error("total of line " + items[i].Asset + " must be positive")
+		}
+		price = price + total
+	}
+
+	//customer pays the shipper's fee on top of the goods
+	shipper, err := getDelivery(stub, delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fee := shipper.Price
+	price = price + fee
+
+	if len(args) == 6 && len(args[5]) > 0 {
+		amount, err := strconv.Atoi(args[5])
+		if err != nil {
+			return shim.Error("amount must be a number")
+		}
+		if amount != price {
+			return shim.Error("amount " + args[5] + " does not match price of order " + strconv.Itoa(price))
+		}
+	}
+
+	//every order starts its lifecycle as created
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	objectType := "Order"
+
+	order := &Order{objectType, id, customer, seller, delivery, items, detail, price, fee, StatusCreated, caller, txTime, false, nil, nil, 0}
+
+	//hold the goods so the seller cannot sell them twice
+	err = reserveStock(stub, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = logOrderEvent(stub, order, "createOrder", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	order_to_byte, err_or := json.Marshal(order)
+	if err_or != nil {
+		return shim.Error(err_or.Error())
+	}
+
+	err_or = stub.PutPrivateData("orderCollection", id, order_to_byte)
+	if err_or != nil {
+		return shim.Error(err_or.Error())
+	}
+
+	//create key
+	indexName := "id~name"
+	orderNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{order.OrderID, order.Customer})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//save key
+	value := []byte{0x00}
+	stub.PutPrivateData("orderCollection", orderNameIndexKey, value)
+
+	end := time.Now()
+	elapsed := time.Since(start)
+
+	fmt.Println("\nfunction createOrder")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end createOrder function ===============")
+
+	return shim.Success([]byte(id))
+}
+
+//shipper accepts an order and holds its price out of his mortgage, args: order id
+func (t *COD_chaincode) acceptOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start acceptOrder function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = changeOrderStatus(stub, &order, StatusAccepted)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mortgage, err := getBalance(stub, "mortgageCollection", order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = holdCollateral(&mortgage, order.OrderID, order.Price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putBalance(stub, "mortgageCollection", &mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = logOrderEvent(stub, &order, "acceptOrder", "collateral held "+strconv.Itoa(order.Price))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction acceptOrder")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end acceptOrder function ===============")
+	return shim.Success(nil)
+}
+
+//customer or seller cancels an order, args: order id, party (customer or seller), reason code
+//cancelling is free before pickup, afterwards the party pays the shipper the delivery fee in a transfer the
+//shipper claims and the parcel goes back to the seller, hold and stock are then released by confirmReturn and settleReturn
+func (t *COD_chaincode) cancelOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start cancelOrder function ===============")
+	start := time.Now()
+	if len(args) != 3 {
+		return shim.Error("expecting 3 arguments, order id, party and reason")
+	}
+
+	id := args[0]
+	party := args[1]
+	reason := args[2]
+	if !cancelReasons[reason] {
+		return shim.Error("unknown cancel reason: " + reason)
+	}
+
+	order, err := getOrder(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payer := ""
+	switch party {
+	case "customer":
+		payer = order.Customer
+	case "seller":
+		payer = order.Seller
+	default:
+		return shim.Error("order can only be cancelled by customer or seller")
+	}
+	err = checkOwner(stub, payer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	stage := order.Status
+	pickedUp := stage == StatusPickedUp || stage == StatusInTransit
+	status := StatusCancelled
+	if pickedUp {
+		status = StatusReturning
+	}
+	err = changeOrderStatus(stub, &order, status)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//parcel has left the seller, shipper is paid for the trip
+	fee := 0
+	detail := party + " " + reason
+	if pickedUp {
+		fee = order.Fee
+		transferID, err := payOrder(stub, &order, payer, order.Delivery, fee, "cancel fee")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(transferID) > 0 {
+			detail = detail + ", fee " + strconv.Itoa(fee) + " by transfer " + transferID
+		}
+	}
+
+	//goods never left the seller
+	if !pickedUp {
+		err = releaseOrderHold(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = releaseStock(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	//keep a record of the cancellation on the order
+	ObjectType := "Cancellation"
+	order.Cancellation = &Cancellation{ObjectType, id, party, order.UpdatedBy, reason, stage, fee, order.UpdatedAt}
+	err = logOrderEvent(stub, &order, "cancelOrder", detail)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction cancelOrder")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end cancelOrder function ===============")
+	return shim.Success(nil)
+}
+
+//give reserved quantity of an order back to the seller's asset
+func releaseStock(stub shim.ChaincodeStubInterface, order *Order) error {
+	if !order.StockReserved {
+		return nil
+	}
+
+	names, quantities := orderQuantities(order)
+	for _, name := range names {
+		asset, err := getAsset(stub, order.Seller, name)
+		if err != nil {
+			return err
+		}
+		asset.Reserved = asset.Reserved - quantities[name]
+		err = putAsset(stub, &asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	order.StockReserved = false
+	return nil
+}
+
+//reserve quantity of every line of an order on the seller's asset
+func reserveStock(stub shim.ChaincodeStubInterface, order *Order) error {
+	names, quantities := orderQuantities(order)
+	for _, name := range names {
+		asset, err := getAsset(stub, order.Seller, name)
+		if err != nil {
+			return err
+		}
+		if asset.Quantity-asset.Reserved < quantities[name] {
+			return fmt.Errorf("%s has only %d of %s left", order.Seller, asset.Quantity-asset.Reserved, name)
+		}
+		asset.Reserved = asset.Reserved + quantities[name]
+		err = putAsset(stub, &asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	order.StockReserved = true
+	return nil
+}
+
+//goods of a delivered order leave the seller's stock for good
+func consumeStock(stub shim.ChaincodeStubInterface, order *Order) error {
+	if !order.StockReserved {
+		return nil
+	}
+
+	names, quantities := orderQuantities(order)
+	for _, name := range names {
+		asset, err := getAsset(stub, order.Seller, name)
+		if err != nil {
+			return err
+		}
+		asset.Reserved = asset.Reserved - quantities[name]
+		asset.Quantity = asset.Quantity - quantities[name]
+		err = putAsset(stub, &asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	order.StockReserved = false
+	return nil
+}
+
+//total quantity per asset of an order, names are sorted so every peer writes in the same order
+func orderQuantities(order *Order) ([]string, map[string]int) {
+	quantities := map[string]int{}
+	names := []string{}
+	for _, item := range order.Items {
+		if _, ok := quantities[item.Asset]; !ok {
+			names = append(names, item.Asset)
+		}
+		quantities[item.Asset] = quantities[item.Asset] + item.Quantity
+	}
+	sort.Strings(names)
+	return names, quantities
+}
+
+//key of an asset in assetCollection, asset names never contain /
+func assetKey(seller string, name string) string {
+	return seller + "/" + name
+}
+
+//get asset of seller from assetCollection
+func getAsset(stub shim.ChaincodeStubInterface, seller string, name string) (Asset, error) {
+	asset := Asset{}
+	assetAsByte, err := stub.GetPrivateData("assetCollection", assetKey(seller, name))
+	if err != nil {
+		return asset, fmt.Errorf("cannot get asset %s of %s: %s", name, seller, err.Error())
+	} else if assetAsByte == nil {
+		return asset, fmt.Errorf("%s does not sell %s", seller, name)
+	}
+
+	err = json.Unmarshal(assetAsByte, &asset)
+	if err != nil {
+		return asset, fmt.Errorf("cannot unmarshal asset %s of %s", name, seller)
+	}
+	return asset, nil
+}
+
+//save asset of seller to assetCollection
+func putAsset(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	assetAsByte, err := json.Marshal(asset)
+	if err != nil {
+		return fmt.Errorf("cannot marshal asset %s of %s", asset.Asset, asset.Name)
+	}
+
+	err = stub.PutPrivateData("assetCollection", assetKey(asset.Name, asset.Asset), assetAsByte)
+	if err != nil {
+		return fmt.Errorf("cannot put asset %s of %s: %s", asset.Asset, asset.Name, err.Error())
+	}
+	return nil
+}
+
+//shipper records that the customer refused the parcel, args: order id, transient input: location, reason
+func (t *COD_chaincode) refuseDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start refuseDelivery function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id, location and reason are passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "location", "reason")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(args, input...)
+	if len(args[1]) == 0 {
+		return shim.Error("location of refusal must be declare")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = changeOrderStatus(stub, &order, StatusReturning)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.Refusal = &Refusal{args[1], args[2], order.UpdatedAt}
+
+	err = logOrderEvent(stub, &order, "refuseDelivery", "refused at "+args[1]+": "+args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction refuseDelivery")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end refuseDelivery function ===============")
+	return shim.Success(nil)
+}
+
+//seller confirms the refused parcel is back and pays the return fee in a transfer the shipper claims, args: order id
+func (t *COD_chaincode) confirmReturn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start confirmReturn function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Seller)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = changeOrderStatus(stub, &order, StatusReturned)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//goods are back on the seller's shelf
+	err = releaseStock(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//the seller pays the return trip of a refused parcel, one cancelled after pickup was paid by the cancel fee
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	detail := ""
+	if order.Refusal != nil && config.ReturnFee > 0 {
+		transferID, err := payOrder(stub, &order, order.Seller, order.Delivery, config.ReturnFee, "return fee")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(transferID) > 0 {
+			detail = "return fee " + strconv.Itoa(config.ReturnFee) + " by transfer " + transferID
+		}
+	}
+	err = logOrderEvent(stub, &order, "confirmReturn", detail)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction confirmReturn")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end confirmReturn function ===============")
+	return shim.Success(nil)
+}
+
+//release shipper's collateral of a returned order, args: order id
+//the shipper can settle on its own once the seller confirmed the return, if the seller has not confirmed it
+//returnTimeout after the parcel started back the collateral is released anyway and the order stays Returning,
+//the seller still takes the goods back and pays the return fee with confirmReturn
+func (t *COD_chaincode) settleReturn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start settleReturn function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Seller, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	detail := ""
+	if order.Status == StatusReturning {
+		err = checkReturnTimeout(stub, &order)
+		detail = "return not confirmed in time, collateral released"
+	} else {
+		err = changeOrderStatus(stub, &order, StatusSettled)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = releaseOrderHold(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = logOrderEvent(stub, &order, "settleReturn", detail)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction settleReturn")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end settleReturn function ===============")
+	return shim.Success(nil)
+}
+
+//a return the seller has not confirmed releases the collateral returnTimeout after the order went Returning
+func checkReturnTimeout(stub shim.ChaincodeStubInterface, order *Order) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	returning, err := time.Parse(time.RFC3339, order.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("cannot parse update time of order %s", order.OrderID)
+	}
+	deadline := returning.Add(returnTimeout).UTC().Format(time.RFC3339)
+	if txTime < deadline {
+		return fmt.Errorf("return of order %s is not confirmed by the seller, it can be settled from %s", order.OrderID, deadline)
+	}
+	return nil
+}
+
+//change the return fee, args: return fee
+func (t *COD_chaincode) setReturnFee(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, return fee")
+	}
+	returnFee, err := strconv.Atoi(args[0])
+	if err != nil || returnFee < 0 {
+		return shim.Error("return fee must be a positive number")
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.ReturnFee = returnFee
+	err = putConfig(stub, &config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//change the balance collection of the admin's org to another of the declared balance collections, args: msp id, collection
+//mortgageCollection or orderCollection would mix balances with collateral and orders
+func (t *COD_chaincode) setBalanceCollection(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("expecting 2 argument, msp id and collection")
+	}
+	if len(args[0]) == 0 || len(args[1]) == 0 {
+		return shim.Error("msp id and collection must be declare")
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("cannot get caller's msp id")
+	}
+	if args[0] != mspID {
+		return shim.Error("admin of " + mspID + " cannot change the balance collection of " + args[0])
+	}
+	if !isBalanceCollection(args[1]) {
+		return shim.Error("collection is not a balance collection: " + args[1])
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config.BalanceCollections[args[0]] = args[1]
+	err = putConfig(stub, &config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//collections declared in collection.json to keep the balances of an org
+func isBalanceCollection(collection string) bool {
+	for _, balanceCollection := range defaultBalanceCollections {
+		if collection == balanceCollection {
+			return true
+		}
+	}
+	return false
+}
+
+//get chaincode configuration from world state
+func getConfig(stub shim.ChaincodeStubInterface) (Config, error) {
+	config := Config{ObjectType: "Config"}
+	configAsByte, err := stub.GetState("config")
+	if err != nil {
+		return config, fmt.Errorf("cannot get config: %s", err.Error())
+	}
+	if configAsByte != nil {
+		err = json.Unmarshal(configAsByte, &config)
+		if err != nil {
+			return config, fmt.Errorf("cannot unmarshal config")
+		}
+	}
+	if config.BalanceCollections == nil {
+		config.BalanceCollections = map[string]string{}
+		for mspID, collection := range defaultBalanceCollections {
+			config.BalanceCollections[mspID] = collection
+		}
+	}
+	return config, nil
+}
+
+//save chaincode configuration to world state
+func putConfig(stub shim.ChaincodeStubInterface, config *Config) error {
+	configAsByte, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("cannot marshal config")
+	}
+	return stub.PutState("config", configAsByte)
+}
+
+//query available and held collateral of shipper, args: identity of shipper
+func (t *COD_chaincode) queryCollateral(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start queryCollateral function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, name of shipper")
+	}
+	err := checkOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mortgage, err := getBalance(stub, "mortgageCollection", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	holds := mortgage.Holds
+	if holds == nil {
+		holds = map[string]int{}
+	}
+	collateral := &Collateral{mortgage.Name, mortgage.DisplayName, mortgage.Balance, mortgage.Held, mortgage.Balance - mortgage.Held, holds}
+	collateralAsByte, err := json.Marshal(collateral)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction queryCollateral")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end queryCollateral function ===============")
+	return shim.Success(collateralAsByte)
+}
+
+//list orders whose field equals the given value with a couchdb selector, args: value, page size (optional), bookmark (optional)
+func (t *COD_chaincode) queryOrdersBy(stub shim.ChaincodeStubInterface, args []string, function string, field string) pb.Response {
+	fmt.Println("\n=============== start " + function + " function ===============")
+	start := time.Now()
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("expecting 1 to 3 argument, " + field + " of order, page size and bookmark")
+	}
+	if len(args[0]) == 0 {
+		return shim.Error(field + " must be declare")
+	}
+	pageSize, bookmark, err := getPageArgs(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//marshal the selector so values cannot break out of the query
+	selector := map[string]interface{}{
+		"docType": "Order",
+		field:     args[0],
+	}
+
+	//callers only see their own orders, by status that is the orders they take part in
+	if field != "status" {
+		err = checkOwner(stub, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		roles, err := getRoles(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !hasRole(roles, RoleAdmin) {
+			caller, err := getCaller(stub)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			selector["$or"] = []map[string]interface{}{{"customer": caller}, {"seller": caller}, {"delivery": caller}}
+		}
+	}
+	page, err := queryPage(stub, "orderCollection", selector, pageSize, bookmark, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ordersAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction " + function)
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end " + function + " function ===============")
+	return shim.Success(ordersAsByte)
+}
+
+//list deliveries with a range scan over deliveryCollection, args: page size (optional), bookmark (optional)
+func (t *COD_chaincode) listDeliveries(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start listDeliveries function ===============")
+	start := time.Now()
+	if len(args) > 2 {
+		return shim.Error("expecting at most 2 argument, page size and bookmark")
+	}
+	pageSize, bookmark, err := getPageArgs(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetPrivateDataByRange("deliveryCollection", bookmark, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page, err := readPage(resultsIterator, pageSize, bookmark, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deliveriesAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction listDeliveries")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end listDeliveries function ===============")
+	return shim.Success(deliveriesAsByte)
+}
+
+//dump documents of a docType from a collection with a range scan, composite index entries are skipped
+//only admins export, so records are never redacted, args: collection, docType, page size (optional), bookmark (optional)
+func (t *COD_chaincode) exportCollection(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start exportCollection function ===============")
+	start := time.Now()
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("expecting 2 to 4 argument, collection, docType, page size and bookmark")
+	}
+	collection := args[0]
+	docType := args[1]
+	if !exportCollections[collection] {
+		return shim.Error("collection cannot be exported: " + collection)
+	}
+	if len(docType) == 0 {
+		return shim.Error("docType must be declare")
+	}
+	pageSize, bookmark, err := getPageArgs(args[2:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetPrivateDataByRange(collection, bookmark, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page, err := readPage(resultsIterator, pageSize, bookmark, func(key string, value []byte) ([]byte, error) {
+		//composite index entries carry no document
+		if len(value) == 1 && value[0] == 0x00 {
+			return nil, nil
+		}
+		header := struct {
+			ObjectType string `json:"docType"`
+		}{}
+		if json.Unmarshal(value, &header) != nil || header.ObjectType != docType {
+			return nil, nil
+		}
+
+		return json.Marshal(Record{docType, key, nil, json.RawMessage(value)})
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction exportCollection")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end exportCollection function ===============")
+	return shim.Success(pageAsByte)
+}
+
+//find shippers serving a location for at most a price, args: location, max price, page size (optional), bookmark (optional)
+func (t *COD_chaincode) findDeliveries(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start findDeliveries function ===============")
+	start := time.Now()
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("expecting 2 to 4 argument, location, max price, page size and bookmark")
+	}
+	if len(args[0]) == 0 {
+		return shim.Error("location must be declare")
+	}
+	maxPrice, err := strconv.Atoi(args[1])
+	if err != nil {
+		return shim.Error("max price must be a number")
+	}
+	pageSize, bookmark, err := getPageArgs(args[2:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	selector := map[string]interface{}{
+		"docType":  "Delivery",
+		"location": args[0],
+	}
+
+	//price is filtered here so couchdb keeps returning results in key order
+	page, err := queryPage(stub, "deliveryCollection", selector, pageSize, bookmark, func(key string, value []byte) ([]byte, error) {
+		delivery := Delivery{}
+		err := json.Unmarshal(value, &delivery)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal delivery %s", key)
+		}
+		if delivery.Price > maxPrice {
+			return nil, nil
+		}
+		return json.Marshal(delivery)
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deliveriesAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction findDeliveries")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end findDeliveries function ===============")
+	return shim.Success(deliveriesAsByte)
+}
+
+//quote the shippers serving a route, args: origin, destination, page size (optional), bookmark (optional)
+//shippers at the origin come first as they pick the parcel up, then the cheapest, fastest and nearest
+//every shipper of the route is ranked before the ranking is paged, the bookmark is a position in it
+func (t *COD_chaincode) quoteDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start quoteDelivery function ===============")
+	start := time.Now()
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("expecting 2 to 4 argument, origin, destination, page size and bookmark")
+	}
+	if len(args[0]) == 0 || len(args[1]) == 0 {
+		return shim.Error("origin and destination must be declare")
+	}
+	origin := args[0]
+	pageSize, bookmark, err := getPageArgs(args[2:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	offset := 0
+	if len(bookmark) > 0 {
+		offset, err = strconv.Atoi(bookmark)
+		if err != nil || offset < 0 {
+			return shim.Error("bookmark is invalid")
+		}
+	}
+
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"docType":  "Delivery",
+			"location": map[string]interface{}{"$in": []string{args[0], args[1]}},
+		},
+	}
+	queryAsByte, err := json.Marshal(query)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator, err := stub.GetPrivateDataQueryResult("deliveryCollection", string(queryAsByte))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	quotes := []DeliveryQuote{}
+	for resultsIterator.HasNext() {
+		if len(quotes) == maxQuoteCandidates {
+			return shim.Error("more than " + strconv.Itoa(maxQuoteCandidates) + " shippers serve this route, use findDeliveries")
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		delivery := Delivery{}
+		err = json.Unmarshal(queryResponse.Value, &delivery)
+		if err != nil {
+			return shim.Error("cannot unmarshal delivery " + queryResponse.Key)
+		}
+		quotes = append(quotes, DeliveryQuote{delivery.Name, delivery.DisplayName, delivery.Location, delivery.Price, delivery.Distance, delivery.Time})
+	}
+
+	//name breaks ties so every peer returns the same ranking
+	sort.Slice(quotes, func(i, j int) bool {
+		if (quotes[i].Location == origin) != (quotes[j].Location == origin) {
+			return quotes[i].Location == origin
+		}
+		if quotes[i].Fee != quotes[j].Fee {
+			return quotes[i].Fee < quotes[j].Fee
+		}
+		if quotes[i].Time != quotes[j].Time {
+			return quotes[i].Time < quotes[j].Time
+		}
+		if quotes[i].Distance != quotes[j].Distance {
+			return quotes[i].Distance < quotes[j].Distance
+		}
+		return quotes[i].Name < quotes[j].Name
+	})
+
+	page := QueryPage{Records: []json.RawMessage{}}
+	for i := offset; i < len(quotes) && page.FetchedRecordsCount < pageSize; i++ {
+		quoteAsByte, err := json.Marshal(quotes[i])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		page.Records = append(page.Records, json.RawMessage(quoteAsByte))
+		page.FetchedRecordsCount = page.FetchedRecordsCount + 1
+	}
+	if offset+page.FetchedRecordsCount < len(quotes) {
+		page.Bookmark = base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(offset + page.FetchedRecordsCount)))
+	}
+
+	quotesAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction quoteDelivery")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end quoteDelivery function ===============")
+	return shim.Success(quotesAsByte)
+}
+
+//run a couchdb selector over a collection and return one page of it
+//results of an equality selector come back in key order, so the query restarts after the bookmark key
+func queryPage(stub shim.ChaincodeStubInterface, collection string, selector map[string]interface{}, pageSize int, bookmark string, record func(key string, value []byte) ([]byte, error)) (QueryPage, error) {
+	if len(bookmark) > 0 {
+		selector["_id"] = map[string]interface{}{"$gt": bookmark}
+	}
+	query := map[string]interface{}{
+		"selector": selector,
+	}
+	queryAsByte, err := json.Marshal(query)
+	if err != nil {
+		return QueryPage{}, err
+	}
+
+	resultsIterator, err := stub.GetPrivateDataQueryResult(collection, string(queryAsByte))
+	if err != nil {
+		return QueryPage{}, err
+	}
+	defer resultsIterator.Close()
+
+	return readPage(resultsIterator, pageSize, bookmark, record)
+}
+
+//page size and bookmark from the optional trailing arguments of a list query
+func getPageArgs(args []string) (int, string, error) {
+	pageSize := defaultPageSize
+	bookmark := ""
+	if len(args) > 0 && len(args[0]) > 0 {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size <= 0 {
+			return 0, "", fmt.Errorf("page size must be a positive number")
+		}
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+		pageSize = size
+	}
+	if len(args) > 1 && len(args[1]) > 0 {
+		key, err := base64.StdEncoding.DecodeString(args[1])
+		if err != nil {
+			return 0, "", fmt.Errorf("bookmark is invalid")
+		}
+		bookmark = string(key)
+	}
+	return pageSize, bookmark, nil
+}
+
+//collect up to pageSize records with keys after bookmark, record turns a result into the returned document
+//and may return nil to leave a result out, the next bookmark is empty on the last page
+//a page stops after maxScannedRows rows even when it has fewer records, its bookmark continues the scan
+func readPage(resultsIterator shim.StateQueryIteratorInterface, pageSize int, bookmark string, record func(key string, value []byte) ([]byte, error)) (QueryPage, error) {
+	page := QueryPage{Records: []json.RawMessage{}}
+	lastKey := ""
+	scanned := 0
+	for resultsIterator.HasNext() {
+		if page.FetchedRecordsCount == pageSize || (scanned == maxScannedRows && len(lastKey) > 0) {
+			page.Bookmark = base64.StdEncoding.EncodeToString([]byte(lastKey))
+			break
+		}
+
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return page, err
+		}
+		scanned = scanned + 1
+		if len(bookmark) > 0 && queryResponse.Key <= bookmark {
+			continue
+		}
+		lastKey = queryResponse.Key
+
+		value := queryResponse.Value
+		if record != nil {
+			value, err = record(queryResponse.Key, queryResponse.Value)
+			if err != nil {
+				return page, err
+			}
+		}
+		//composite index entries carry no document
+		if value == nil || (len(value) == 1 && value[0] == 0x00) {
+			continue
+		}
+		page.Records = append(page.Records, json.RawMessage(value))
+		page.FetchedRecordsCount = page.FetchedRecordsCount + 1
+	}
+	return page, nil
+}
+
+//reserve amount of free collateral for an order
+func holdCollateral(mortgage *Balance, orderID string, amount int) error {
+	if _, ok := mortgage.Holds[orderID]; ok {
+		return fmt.Errorf("collateral of %s is already held for order %s", mortgage.Name, orderID)
+	}
+	if mortgage.Balance-mortgage.Held < amount {
+		return fmt.Errorf("%s does not have enough free collateral for order %s", mortgage.Name, orderID)
+	}
+	if mortgage.Holds == nil {
+		mortgage.Holds = map[string]int{}
+	}
+	mortgage.Holds[orderID] = amount
+	mortgage.Held = mortgage.Held + amount
+	return nil
+}
+
+//give back collateral held for an order, returns the released amount
+func releaseCollateral(mortgage *Balance, orderID string) int {
+	amount, ok := mortgage.Holds[orderID]
+	if !ok {
+		return 0
+	}
+	delete(mortgage.Holds, orderID)
+	mortgage.Held = mortgage.Held - amount
+	return amount
+}
+
+//release the shipper's hold for an order if there is one
+func releaseOrderHold(stub shim.ChaincodeStubInterface, order *Order) error {
+	mortgageAsByte, err := stub.GetPrivateData("mortgageCollection", order.Delivery)
+	if err != nil {
+		return fmt.Errorf("cannot get mortgage of %s: %s", order.Delivery, err.Error())
+	} else if mortgageAsByte == nil {
+		return nil
+	}
+	mortgage := Balance{}
+	err = json.Unmarshal(mortgageAsByte, &mortgage)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal mortgage of %s", order.Delivery)
+	}
+	if releaseCollateral(&mortgage, order.OrderID) == 0 {
+		return nil
+	}
+	return putBalance(stub, "mortgageCollection", &mortgage)
+}
+
+//append an event to the history of an order, events are never overwritten
+//the order keeps the event count so the caller has to save the order afterwards
+func logOrderEvent(stub shim.ChaincodeStubInterface, order *Order, event string, detail string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	sequence := order.Events
+	ObjectType := "OrderEvent"
+	orderEvent := &OrderEvent{ObjectType, order.OrderID, sequence, event, order.Status, detail, caller, stub.GetTxID(), txTime}
+	orderEventAsByte, err := json.Marshal(orderEvent)
+	if err != nil {
+		return err
+	}
+
+	//sequence is padded so events sort in the order they happened
+	eventKey, err := stub.CreateCompositeKey("orderID~sequence", []string{order.OrderID, fmt.Sprintf("%010d", sequence)})
+	if err != nil {
+		return err
+	}
+	err = stub.PutPrivateData("orderCollection", eventKey, orderEventAsByte)
+	if err != nil {
+		return fmt.Errorf("cannot put event of order %s: %s", order.OrderID, err.Error())
+	}
+	order.Events = sequence + 1
+	return nil
+}
+
+//get events of an order of the caller in the order they happened, args: order id, page size (optional), bookmark (optional)
+func (t *COD_chaincode) getOrderHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start getOrderHistory function ===============")
+	start := time.Now()
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("expecting 1 to 3 argument, order id, page size and bookmark")
+	}
+	pageSize, bookmark, err := getPageArgs(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Customer, order.Seller, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey("orderCollection", "orderID~sequence", []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page, err := readPage(resultsIterator, pageSize, bookmark, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	historyAsByte, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction getOrderHistory")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end getOrderHistory function ===============")
+	return shim.Success(historyAsByte)
+}
+
+//order of the caller, its hash, every shipper verification and its limit time in one document, args: order id
+//sections kept in collections the caller's org cannot read are left out, the order itself must be readable
+func (t *COD_chaincode) getOrderDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start getOrderDetails function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id")
+	}
+
+	id := args[0]
+	details := OrderDetails{OrderID: id}
+
+	//only the parties of the order see its timeline
+	order := Order{}
+	ok, err := readDetail(stub, "orderCollection", id, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !ok {
+		return shim.Error("order does not exist or cannot be read: " + id)
+	}
+	err = checkOwner(stub, order.Customer, order.Seller, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	details.Order = &order
+
+	//a section is left out when it does not exist or the caller's org cannot read its collection
+	orderHash := OrderHash{}
+	ok, err = readDetail(stub, "assetHashCollection", id, &orderHash)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ok {
+		details.OrderHash = &orderHash
+	}
+
+	//every verification leaves an index key, the record itself only keeps the latest one
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey("verifyShipperCollection", "OrderID~Hash", []string{"VerifyShipper", id})
+	if err != nil && !isAccessDenied(err) {
+		return shim.Error("cannot get verifications of order " + id + ": " + err.Error())
+	} else if err == nil {
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			responseRange, err := resultsIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if len(keyParts) != 5 {
+				return shim.Error("malformed verification key of order " + id)
+			}
+			details.VerifyShipper = append(details.VerifyShipper, VerifyShipper{keyParts[0], keyParts[1], keyParts[2], keyParts[3], keyParts[4]})
+		}
+	}
+
+	limitTime := LimitTime{}
+	ok, err = readDetail(stub, "limitTimeCollection", id, &limitTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ok {
+		details.LimitTime = &limitTime
+	}
+
+	detailsAsByte, err := json.Marshal(details)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction getOrderDetails")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end getOrderDetails function ===============")
+	return shim.Success(detailsAsByte)
+}
+
+//revenue and order counts of a seller for orders last changed in a date range, args: seller, from, to
+//dates are YYYY-MM-DD or RFC3339, an empty date leaves that end of the range open
+func (t *COD_chaincode) sellerReport(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start sellerReport function ===============")
+	start := time.Now()
+	if len(args) != 3 {
+		return shim.Error("expecting 3 argument, seller, from and to")
+	}
+	err := checkOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	from, to, err := getDateRange(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	report := SellerReport{Seller: args[0], From: from, To: to}
+	err = forEachOrder(stub, "seller", args[0], func(order Order) error {
+		if !inDateRange(order.UpdatedAt, from, to) {
+			return nil
+		}
+		report.Orders = report.Orders + 1
+		//an order cancelled after pickup ends Settled like a delivered one
+		switch {
+		case order.Status == StatusCancelled || order.Cancellation != nil:
+			report.Cancelled = report.Cancelled + 1
+		case order.Refusal != nil && (order.Status == StatusReturned || order.Status == StatusSettled):
+			report.Returned = report.Returned + 1
+		case order.Status == StatusSettled:
+			//seller gets the order amount less the shipper's fee
+			report.Delivered = report.Delivered + 1
+			report.Revenue = report.Revenue + order.Price - order.Fee
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	collection, err := orgBalanceCollection(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balance, err := getBalance(stub, collection, args[0])
+	if err == nil {
+		report.Balance = balance.Balance
+	}
+
+	reportAsByte, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction sellerReport")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end sellerReport function ===============")
+	return shim.Success(reportAsByte)
+}
+
+//delivered, refused and cancelled counts, collected cod and collateral of a shipper in a date range, args: shipper, from, to
+func (t *COD_chaincode) shipperReport(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start shipperReport function ===============")
+	start := time.Now()
+	if len(args) != 3 {
+		return shim.Error("expecting 3 argument, shipper, from and to")
+	}
+	err := checkOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	from, to, err := getDateRange(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	report := ShipperReport{Shipper: args[0], From: from, To: to}
+	err = forEachOrder(stub, "delivery", args[0], func(order Order) error {
+		if !inDateRange(order.UpdatedAt, from, to) {
+			return nil
+		}
+		//the shipper collected no cod for a cancelled order, only the trip is paid once picked up
+		switch {
+		case order.Cancellation != nil:
+			report.Cancelled = report.Cancelled + 1
+			report.FeesEarned = report.FeesEarned + order.Cancellation.Fee
+		case order.Refusal != nil:
+			report.Refused = report.Refused + 1
+		case order.Status == StatusSettled:
+			report.Delivered = report.Delivered + 1
+			report.CollectedCOD = report.CollectedCOD + order.Price
+			report.FeesEarned = report.FeesEarned + order.Fee
+		}
+
+		//verifications are only readable by members of verifyShipperCollection
+		resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey("verifyShipperCollection", "OrderID~Hash", []string{"VerifyShipper", order.OrderID})
+		if err != nil && isAccessDenied(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			responseRange, err := resultsIterator.Next()
+			if err != nil {
+				return err
+			}
+			_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+			if err == nil && len(keyParts) == 5 && keyParts[3] == "verify failed" {
+				report.FailedVerifications = report.FailedVerifications + 1
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//collateral is still outstanding for orders the shipper has not settled yet
+	mortgage, err := getBalance(stub, "mortgageCollection", args[0])
+	if err == nil {
+		report.HeldCollateral = mortgage.Held
+		report.OpenOrders = len(mortgage.Holds)
+	}
+
+	reportAsByte, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction shipperReport")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end shipperReport function ===============")
+	return shim.Success(reportAsByte)
+}
+
+//call fn for every order whose field equals value
+func forEachOrder(stub shim.ChaincodeStubInterface, field string, value string, fn func(order Order) error) error {
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"docType": "Order",
+			field:     value,
+		},
+	}
+	queryAsByte, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+
+	resultsIterator, err := stub.GetPrivateDataQueryResult("orderCollection", string(queryAsByte))
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		order := Order{}
+		err = json.Unmarshal(queryResponse.Value, &order)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal order %s", queryResponse.Key)
+		}
+		err = fn(order)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//turn report dates into RFC3339 bounds, a day covers all of its hours
+func getDateRange(from string, to string) (string, string, error) {
+	if len(from) == 10 {
+		from = from + "T00:00:00Z"
+	}
+	if len(to) == 10 {
+		to = to + "T23:59:59Z"
+	}
+	if len(from) > 0 {
+		fromTime, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return "", "", fmt.Errorf("from must be a date YYYY-MM-DD")
+		}
+		from = fromTime.UTC().Format(time.RFC3339)
+	}
+	if len(to) > 0 {
+		toTime, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return "", "", fmt.Errorf("to must be a date YYYY-MM-DD")
+		}
+		to = toTime.UTC().Format(time.RFC3339)
+	}
+	return from, to, nil
+}
+
+//timestamps are written in UTC RFC3339 so they compare as strings
+func inDateRange(timestamp string, from string, to string) bool {
+	if len(from) > 0 && timestamp < from {
+		return false
+	}
+	if len(to) > 0 && timestamp > to {
+		return false
+	}
+	return true
+}
+
+//move an order one step along its lifecycle, args: order id
+func (t *COD_chaincode) updateOrderStatus(stub shim.ChaincodeStubInterface, args []string, function string, status string) pb.Response {
+	fmt.Println("\n=============== start " + function + " function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = changeOrderStatus(stub, &order, status)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//parcel never left the seller, shipper gets collateral back
+	if status == StatusFailed {
+		err = releaseOrderHold(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = releaseStock(stub, &order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = logOrderEvent(stub, &order, function, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+	fmt.Println("\nfunction " + function)
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end " + function + " function ===============")
+	return shim.Success(nil)
+}
+
+//check that the order may move to status and stamp who moved it and when
+func changeOrderStatus(stub shim.ChaincodeStubInterface, order *Order, status string) error {
+	allowed := false
+	for _, next := range orderTransitions[order.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("order %s cannot change from %s to %s", order.OrderID, order.Status, status)
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	order.Status = status
+	order.UpdatedBy = caller
+	order.UpdatedAt = txTime
+	return nil
+}
+
+//get order from orderCollection
+func getOrder(stub shim.ChaincodeStubInterface, id string) (Order, error) {
+	order := Order{}
+	orderAsByte, err := stub.GetPrivateData("orderCollection", id)
+	if err != nil {
+		return order, fmt.Errorf("cannot get order %s: %s", id, err.Error())
+	} else if orderAsByte == nil {
+		return order, fmt.Errorf("order does not exist: %s", id)
+	}
+
+	err = json.Unmarshal(orderAsByte, &order)
+	if err != nil {
+		return order, fmt.Errorf("cannot unmarshal order %s", id)
+	}
+	return order, nil
+}
+
+//save order to orderCollection
+func putOrder(stub shim.ChaincodeStubInterface, order *Order) error {
+	orderAsByte, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("cannot marshal order %s", order.OrderID)
+	}
+
+	err = stub.PutPrivateData("orderCollection", order.OrderID, orderAsByte)
+	if err != nil {
+		return fmt.Errorf("cannot put order %s: %s", order.OrderID, err.Error())
+	}
+	return nil
+}
+
+//refuse to overwrite a key that already exists in collection
+func checkNotExist(stub shim.ChaincodeStubInterface, collection string, key string) error {
+	valAsBytes, err := stub.GetPrivateData(collection, key)
+	if err != nil {
+		return fmt.Errorf("cannot check %s in %s: %s", key, collection, err.Error())
+	} else if valAsBytes != nil {
+		return fmt.Errorf("%s already exists in %s", key, collection)
+	}
+	return nil
+}
+
+//read one section of getOrderDetails, false when the record does not exist or the caller's org cannot read the collection
+func readDetail(stub shim.ChaincodeStubInterface, collection string, key string, v interface{}) (bool, error) {
+	valAsBytes, err := stub.GetPrivateData(collection, key)
+	if err != nil {
+		if isAccessDenied(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot get %s from %s: %s", key, collection, err.Error())
+	} else if valAsBytes == nil {
+		return false, nil
 	}
 
-	id := args[0]
-	customer := args[1]
-	seller := args[2]
-	delivery := args[3]
-	assetname := args[4]
-	detail := args[5]
-	quantity, err_qu := strconv.Atoi(args[6])
-	price, err_pr := strconv.Atoi(args[7])
-	status := args[8]
-
-	if err_qu != nil {
-		return shim.Error("quantity must be a number")
+	err = json.Unmarshal(valAsBytes, v)
+	if err != nil {
+		return false, fmt.Errorf("cannot unmarshal %s from %s", key, collection)
 	}
-	if err_pr != nil {
-		return shim.Error("price must be a number")
+	return true, nil
+}
+
+//peers refuse reads of a memberOnlyRead collection when the caller's org is not a member
+func isAccessDenied(err error) bool {
+	return strings.Contains(err.Error(), "does not have read access")
+}
+
+//identify the caller by msp id and certificate common name
+func getCaller(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("cannot get caller's msp id: %s", err.Error())
+	}
+	enrollmentID, found, err := cid.GetAttributeValue(stub, "hf.EnrollmentID")
+	if err == nil && found {
+		return mspID + "/" + enrollmentID, nil
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return "", fmt.Errorf("cannot get caller's certificate: %s", err.Error())
 	}
+	return mspID + "/" + cert.Subject.CommonName, nil
+}
 
-	objectType := "Order"
+//roles of the caller from the cod.role attribute of its certificate, each must be allowed for the caller's org
+func getRoles(stub shim.ChaincodeStubInterface) ([]string, error) {
+	value, found, err := cid.GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get caller's %s attribute: %s", roleAttribute, err.Error())
+	}
+	if !found || len(strings.TrimSpace(value)) == 0 {
+		return nil, fmt.Errorf("caller has no %s attribute, enroll with a %s of customer, seller, shipper or admin", roleAttribute, roleAttribute)
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get caller's msp id: %s", err.Error())
+	}
 
-	order := &Order{objectType, id, customer, seller, delivery, assetname, detail, quantity, price, status}
-	order_to_byte, err_or := json.Marshal(order)
-	if err_or != nil {
-		return shim.Error(err_or.Error())
+	roles := []string{}
+	for _, role := range strings.Split(value, ",") {
+		role = strings.TrimSpace(role)
+		if !hasRole(allRoles, role) {
+			return nil, fmt.Errorf("unknown role in %s attribute: %s", roleAttribute, role)
+		}
+		if !roleOrgs[role][mspID] {
+			return nil, fmt.Errorf("role %s cannot be held by a member of %s", role, mspID)
+		}
+		roles = append(roles, role)
 	}
+	return roles, nil
+}
 
-	err_or = stub.PutPrivateData("orderCollection", id, order_to_byte)
-	if err_or != nil {
-		return shim.Error(err_or.Error())
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
 	}
+	return false
+}
 
-	//create key
-	indexName := "id~name"
-	orderNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{order.OrderID, order.Customer})
+//make sure the caller holds one of the roles allowed to call function
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	allowed, ok := functionRoles[function]
+	if !ok {
+		return fmt.Errorf("no access policy for function %s", function)
+	}
+	roles, err := getRoles(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
+	}
+	for _, role := range allowed {
+		if hasRole(roles, role) {
+			return nil
+		}
 	}
+	return fmt.Errorf("caller with roles %v cannot call %s", roles, function)
+}
 
-	//save key
-	value := []byte{0x00}
-	stub.PutPrivateData("orderCollection", orderNameIndexKey, value)
+//make sure the caller is one of the participants ids, admins act for anyone of their own org
+func checkOwner(stub shim.ChaincodeStubInterface, ids ...string) error {
+	roles, err := getRoles(stub)
+	if err != nil {
+		return err
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	mspID := strings.SplitN(caller, "/", 2)[0]
+	for _, id := range ids {
+		if caller == id {
+			return nil
+		}
+		if hasRole(roles, RoleAdmin) && strings.HasPrefix(id, mspID+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("caller %s cannot act for %s", caller, strings.Join(ids, " or "))
+}
 
-	end := time.Now()
-	elapsed := time.Since(start)
+//identity a participant is registered under, the caller's own unless args[index] names another one the caller may act for
+func getParticipantID(stub shim.ChaincodeStubInterface, args []string, index int) (string, error) {
+	if len(args) <= index || len(args[index]) == 0 {
+		return getCaller(stub)
+	}
+	err := checkOwner(stub, args[index])
+	if err != nil {
+		return "", err
+	}
+	return args[index], nil
+}
 
-	fmt.Println("\nfunction createOrder")
-	fmt.Println("time start: ", start.String())
-	fmt.Println("time end: ", end.String())
-	fmt.Println("time execute: ", elapsed.String())
-	printMemUsage()
-	fmt.Println("\n=============== end createOrder function ===============")
+//private arguments of a function from the json object in the input field of the transient map,
+//unlike args they are not written to the block, values come back in the order of fields and missing ones are empty
+func getTransientArgs(stub shim.ChaincodeStubInterface, fields ...string) ([]string, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get transient map: %s", err.Error())
+	}
+	inputAsByte, ok := transientMap[transientKey]
+	if !ok || len(inputAsByte) == 0 {
+		return nil, fmt.Errorf("%s must be passed in the %s field of the transient map", strings.Join(fields, ", "), transientKey)
+	}
 
-	return shim.Success(nil)
+	input := map[string]json.RawMessage{}
+	err = json.Unmarshal(inputAsByte, &input)
+	if err != nil {
+		return nil, fmt.Errorf("transient %s must be a json object", transientKey)
+	}
+	for name := range input {
+		known := false
+		for _, field := range fields {
+			known = known || field == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown field %s in transient %s", name, transientKey)
+		}
+	}
+
+	//strings are unquoted, numbers and lists like items are kept as json
+	values := []string{}
+	for _, field := range fields {
+		value := ""
+		if raw, ok := input[field]; ok && string(raw) != "null" {
+			if json.Unmarshal(raw, &value) != nil {
+				value = string(raw)
+			}
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+//transaction timestamp, the same on every endorsing peer
+func getTxTime(stub shim.ChaincodeStubInterface) (string, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("cannot get transaction time: %s", err.Error())
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339), nil
+}
+
+//decode lines of an order from json
+func parseOrderItems(itemsAsJson string) ([]OrderItem, error) {
+	items := []OrderItem{}
+	err := json.Unmarshal([]byte(itemsAsJson), &items)
+	if err != nil {
+		return nil, fmt.Errorf("items must be a json array of asset, variant, quantity and unitprice")
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("order must have at least 1 item")
+	}
+	for i, item := range items {
+		if len(item.Asset) == 0 {
+			return nil, fmt.Errorf("asset of item %d must be declare", i)
+		}
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("quantity of item %d must be greater than 0", i)
+		}
+		if item.UnitPrice < 0 {
+			return nil, fmt.Errorf("unit price of item %d must not be negative", i)
+		}
+	}
+	return items, nil
+}
+
+//hash of a parcel, covers seller, detail and every line in order
+func hashOrderItems(sellerID string, detail string, items []OrderItem) string {
+	hash := sha256.New()
+	hash.Write([]byte(sellerID + detail))
+	for _, item := range items {
+		hash.Write([]byte(item.Asset + "|" + item.Variant + "|" + strconv.Itoa(item.Quantity) + "|" + strconv.Itoa(item.UnitPrice) + ";"))
+	}
+	md := hash.Sum(nil)
+	return hex.EncodeToString(md)
 }
 
 func (t *COD_chaincode) createAssetHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("\n=============== start createAssetHash function ===============")
 	start := time.Now()
 	time.Sleep(time.Second)
-	if len(args) != 6 {
-		return shim.Error("expting 6 parameters")
+	if len(args) != 1 {
+		return shim.Error("expting 1 parameter, order id")
 	}
 	OrderID := args[0]
-	sellerId := args[1]
-	asset := args[2]
-	detail := args[3]
-	quantity, err1 := strconv.Atoi(args[4])
-	if err1 != nil {
-		return shim.Error(err1.Error())
+
+	//hash commits to every line of the order
+	order, err := getOrder(stub, OrderID)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	price, err2 := strconv.Atoi(args[5])
-	if err2 != nil {
-		return shim.Error(err2.Error())
+	err = checkOwner(stub, order.Seller)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	ObjectType := "AssetHash"
-	hash := sha256.New()
-	hash.Write([]byte(sellerId + asset + detail + string(quantity) + string(price)))
-	md := hash.Sum(nil)
-	asset_hash := hex.EncodeToString(md)
+	asset_hash := hashOrderItems(order.Seller, order.Detail, order.Items)
 
 	AssetHash := &OrderHash{ObjectType, OrderID, asset_hash}
 	AssetHashToByte, err := json.Marshal(AssetHash)
@@ -620,6 +3595,15 @@ func (t *COD_chaincode) createAssetHash(stub shim.ChaincodeStubInterface, args [
 	value := []byte{0x00}
 	stub.PutPrivateData("assetHashCollection", orderHashIndexKey, value)
 
+	err = logOrderEvent(stub, &order, "createAssetHash", asset_hash)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	end := time.Now()
 	elapsed := time.Since(start)
 
@@ -680,27 +3664,35 @@ func (t *COD_chaincode) verifyShipper(stub shim.ChaincodeStubInterface, args []s
 	fmt.Println("\n=============== start verifyShipper function ===============")
 	start := time.Now()
 	time.Sleep(time.Second)
-	if len(args) != 3 {
-		return shim.Error("there must be 3 arguments")
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id, items of parcel and location are passed in the transient input")
 	}
-
-	orderHash := OrderHash{}
+	input, errInput := getTransientArgs(stub, "items", "location")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(args, input...)
 
 	id := args[0]
-	//get value as byte
-	valAsBytes, err := stub.GetPrivateData("assetHashCollection", id)
+	orderHash, err := getOrderHash(stub, id)
 	if err != nil {
-		return shim.Error("Failed to get state for " + id + ": " + err.Error() + "\"}")
-	} else if valAsBytes == nil {
-		return shim.Error("object does not exist: " + id + "\"")
+		return shim.Error(err.Error())
 	}
-	//unmarshal data to orderHash
-	err = json.Unmarshal(valAsBytes, &orderHash)
+
+	//hash what the shipper actually carries the same way the seller did
+	order, err := getOrder(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Delivery)
 	if err != nil {
-		return shim.Error("cannot unmarshal data")
+		return shim.Error(err.Error())
 	}
-
-	hashString := args[1]
+	items, err := parseOrderItems(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	hashString := hashOrderItems(order.Seller, order.Detail, items)
 	location := args[2]
 	status := ""
 
@@ -710,29 +3702,21 @@ func (t *COD_chaincode) verifyShipper(stub shim.ChaincodeStubInterface, args []s
 	} else {
 		status = "verify failed"
 	}
-	ObjectType := "VerifyShipper"
-	verify := &VerifyShipper{ObjectType, id, hashString, status, location}
-	VerifyToByte, errVerify := json.Marshal(verify)
-	if errVerify != nil {
+
+	err = putVerifyShipper(stub, id, hashString, status, location)
+	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	err = stub.PutPrivateData("verifyShipperCollection", id, VerifyToByte)
+	err = logOrderEvent(stub, &order, "verifyShipper", status+" at "+location)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	//create key
-	indexName := "OrderID~Hash"
-	orderHashIndexKey, errKey := stub.CreateCompositeKey(indexName, []string{ObjectType, id, hashString, status, location})
-	if errKey != nil {
-		return shim.Error(errKey.Error())
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	//save key
-	value := []byte{0x00}
-	stub.PutPrivateData("verifyShipperCollection", orderHashIndexKey, value)
-
 	end := time.Now()
 	elapsed := time.Since(start)
 
@@ -745,30 +3729,260 @@ func (t *COD_chaincode) verifyShipper(stub shim.ChaincodeStubInterface, args []s
 	return shim.Success(nil)
 }
 
+//confirm delivery and settle the cash collected by the shipper in one transaction, the seller's share leaves
+//the shipper's collateral in a transfer the seller claims
+//the parcel is checked against the seller's hash by verifyShipper first, assetHashCollection is not readable by customers
+//args: order id, transient input: location
+func (t *COD_chaincode) confirmDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("\n=============== start confirmDelivery function ===============")
+	start := time.Now()
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id, location is passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "location")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(args, input...)
+
+	id := args[0]
+	location := args[1]
+	order, err := getOrder(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Customer, order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//the last verification of the parcel must have matched the hash the seller registered
+	verify, err := getVerifyShipper(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if verify.Status != "verify successul" {
+		return shim.Error("parcel of order " + id + " did not pass verifyShipper, delivery is not confirmed")
+	}
+	err = changeOrderStatus(stub, &order, StatusDelivered)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//shipper keeps the delivery fee out of the cash, the rest of the order amount goes to the seller
+	//the hold placed when the shipper accepted the order now pays the seller, no org balance is touched
+	//a seller carrying its own parcel already has the cash
+	payout := order.Price - order.Fee
+	if order.Seller == order.Delivery {
+		payout = 0
+	}
+	mortgage, err := getBalance(stub, "mortgageCollection", order.Delivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	releaseCollateral(&mortgage, id)
+	if mortgage.Balance-mortgage.Held < payout {
+		return shim.Error("shipper's mortgage is not enough to settle order " + id)
+	}
+	mortgage.Balance = mortgage.Balance - payout
+	err = putBalance(stub, "mortgageCollection", &mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	detail := "delivered at " + location
+	if payout > 0 {
+		transferID, err := newTransfer(stub, order.Delivery, order.Seller, payout, id, true)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		detail = detail + ", " + strconv.Itoa(payout) + " to seller by transfer " + transferID
+	}
+
+	err = consumeStock(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = changeOrderStatus(stub, &order, StatusSettled)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = logOrderEvent(stub, &order, "confirmDelivery", detail)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	end := time.Now()
+	elapsed := time.Since(start)
+
+	fmt.Println("\nfunction confirmDelivery")
+	fmt.Println("time start: ", start.String())
+	fmt.Println("time end: ", end.String())
+	fmt.Println("time execute: ", elapsed.String())
+	printMemUsage()
+	fmt.Println("\n=============== end confirmDelivery function ===============")
+	return shim.Success(nil)
+}
+
+//get hash of order from assetHashCollection
+func getOrderHash(stub shim.ChaincodeStubInterface, id string) (OrderHash, error) {
+	orderHash := OrderHash{}
+	valAsBytes, err := stub.GetPrivateData("assetHashCollection", id)
+	if err != nil {
+		return orderHash, fmt.Errorf("Failed to get state for %s: %s", id, err.Error())
+	} else if valAsBytes == nil {
+		return orderHash, fmt.Errorf("object does not exist: %s", id)
+	}
+
+	err = json.Unmarshal(valAsBytes, &orderHash)
+	if err != nil {
+		return orderHash, fmt.Errorf("cannot unmarshal data")
+	}
+	return orderHash, nil
+}
+
+//get the last verification of the parcel of an order from verifyShipperCollection
+func getVerifyShipper(stub shim.ChaincodeStubInterface, id string) (VerifyShipper, error) {
+	verify := VerifyShipper{}
+	verifyAsByte, err := stub.GetPrivateData("verifyShipperCollection", id)
+	if err != nil {
+		return verify, fmt.Errorf("cannot get verification of order %s: %s", id, err.Error())
+	} else if verifyAsByte == nil {
+		return verify, fmt.Errorf("parcel of order %s is not verified", id)
+	}
+
+	err = json.Unmarshal(verifyAsByte, &verify)
+	if err != nil {
+		return verify, fmt.Errorf("cannot unmarshal verification of order %s", id)
+	}
+	return verify, nil
+}
+
+//save result of verifying shipper and its index key
+func putVerifyShipper(stub shim.ChaincodeStubInterface, id string, hashString string, status string, location string) error {
+	ObjectType := "VerifyShipper"
+	verify := &VerifyShipper{ObjectType, id, hashString, status, location}
+	VerifyToByte, err := json.Marshal(verify)
+	if err != nil {
+		return err
+	}
+
+	err = stub.PutPrivateData("verifyShipperCollection", id, VerifyToByte)
+	if err != nil {
+		return err
+	}
+
+	//create key
+	indexName := "OrderID~Hash"
+	orderHashIndexKey, err := stub.CreateCompositeKey(indexName, []string{ObjectType, id, hashString, status, location})
+	if err != nil {
+		return err
+	}
+
+	//save key
+	value := []byte{0x00}
+	return stub.PutPrivateData("verifyShipperCollection", orderHashIndexKey, value)
+}
+
+//get delivery from deliveryCollection
+func getDelivery(stub shim.ChaincodeStubInterface, name string) (Delivery, error) {
+	delivery := Delivery{}
+	deliveryAsByte, err := stub.GetPrivateData("deliveryCollection", name)
+	if err != nil {
+		return delivery, fmt.Errorf("cannot get delivery %s: %s", name, err.Error())
+	} else if deliveryAsByte == nil {
+		return delivery, fmt.Errorf("delivery does not exist: %s", name)
+	}
+
+	err = json.Unmarshal(deliveryAsByte, &delivery)
+	if err != nil {
+		return delivery, fmt.Errorf("cannot unmarshal delivery %s", name)
+	}
+	return delivery, nil
+}
+
+//get balance of owner from collection
+func getBalance(stub shim.ChaincodeStubInterface, collection string, name string) (Balance, error) {
+	balance := Balance{}
+	balanceAsByte, err := stub.GetPrivateData(collection, name)
+	if err != nil {
+		return balance, fmt.Errorf("cannot get balance of %s: %s", name, err.Error())
+	} else if balanceAsByte == nil {
+		return balance, fmt.Errorf("balance of %s does not exist in %s", name, collection)
+	}
+
+	err = json.Unmarshal(balanceAsByte, &balance)
+	if err != nil {
+		return balance, fmt.Errorf("cannot unmarshal balance of %s", name)
+	}
+	return balance, nil
+}
+
+//save balance of owner to collection
+func putBalance(stub shim.ChaincodeStubInterface, collection string, balance *Balance) error {
+	balanceAsByte, err := json.Marshal(balance)
+	if err != nil {
+		return fmt.Errorf("cannot marshal balance of %s", balance.Name)
+	}
+
+	err = stub.PutPrivateData(collection, balance.Name, balanceAsByte)
+	if err != nil {
+		return fmt.Errorf("cannot put balance of %s: %s", balance.Name, err.Error())
+	}
+	return nil
+}
+
+//add amounts to the org balances of participants, negative amounts are payments and need free balance
+//each balance is read and put once, so a participant on both sides of a payment nets out
+func changeBalances(stub shim.ChaincodeStubInterface, changes map[string]int, reason string) error {
+	names := []string{}
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		collection, err := orgBalanceCollection(stub, name)
+		if err != nil {
+			return err
+		}
+		balance, err := getBalance(stub, collection, name)
+		if err != nil {
+			return err
+		}
+		balance.Balance = balance.Balance + changes[name]
+		//money held as collateral cannot be paid
+		if changes[name] < 0 && balance.Balance < balance.Held {
+			return fmt.Errorf("%s does not have enough balance to pay %s", name, reason)
+		}
+		err = putBalance(stub, collection, &balance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (t *COD_chaincode) encrypAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("\n=============== start encrypAsset function ===============")
 	start := time.Now()
 	time.Sleep(time.Second)
-	if len(args) != 5 {
-		return shim.Error("expecting 5 argument")
+	if len(args) != 3 {
+		return shim.Error("expecting 3 argument")
 	}
 
 	sellerID := args[0]
-	asset := args[1]
-	detail := args[2]
-	quantity, err1 := strconv.Atoi(args[3])
-	if err1 != nil {
-		return shim.Error(err1.Error())
-	}
-	price, err2 := strconv.Atoi(args[4])
-	if err2 != nil {
-		return shim.Error(err2.Error())
+	detail := args[1]
+	items, err := parseOrderItems(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	hash := sha256.New()
-	hash.Write([]byte(sellerID + asset + detail + string(quantity) + string(price)))
-	md := hash.Sum(nil)
-	asset_hash := hex.EncodeToString(md)
+	asset_hash := hashOrderItems(sellerID, detail, items)
 
 	fmt.Println("order's hash: ", asset_hash)
 	// fmt.Println("image's hash: ", imageHash)
@@ -781,22 +3995,41 @@ func (t *COD_chaincode) encrypAsset(stub shim.ChaincodeStubInterface, args []str
 	printMemUsage()
 	fmt.Println("\n=============== end encrypAsset function ===============")
 
-	return shim.Success(nil)
+	return shim.Success([]byte(asset_hash))
 }
 
+//seller sets the time limit of an order, args: order id, the limit time and day are passed in the transient input
 func (t *COD_chaincode) dealLimitTime(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("\n=============== start dealLimitTime function ===============")
 	start := time.Now()
 	time.Sleep(time.Second)
 
-	if len(args) != 5 {
-		return shim.Error("expecting 5 argument")
+	if len(args) != 1 {
+		return shim.Error("expecting 1 argument, order id, the limit time and day are passed in the transient input")
+	}
+	input, errInput := getTransientArgs(stub, "limittime", "day")
+	if errInput != nil {
+		return shim.Error(errInput.Error())
+	}
+	args = append(args, input...)
+	if len(args[1]) == 0 || len(args[2]) == 0 {
+		return shim.Error("limit time and day must be declare")
 	}
 	orderID := args[0]
-	sellerID := args[1]
-	deliveryID := args[2]
-	orderTime := args[3]
-	orderDay := args[4]
+	orderTime := args[1]
+	orderDay := args[2]
+
+	//limit time is set by the seller of an existing order, the parties are the order's own
+	order, err := getOrder(stub, orderID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOwner(stub, order.Seller)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sellerID := order.Seller
+	deliveryID := order.Delivery
 
 	ObjectType := "LimitTime"
 	limitTime := &LimitTime{ObjectType, orderID, sellerID, deliveryID, orderTime, orderDay}
@@ -821,6 +4054,15 @@ func (t *COD_chaincode) dealLimitTime(stub shim.ChaincodeStubInterface, args []s
 	value := []byte{0x00}
 	stub.PutPrivateData("limitTimeCollection", orderIDIndexKey, value)
 
+	errLimitTime = logOrderEvent(stub, &order, "dealLimitTime", orderTime+" "+orderDay)
+	if errLimitTime != nil {
+		return shim.Error(errLimitTime.Error())
+	}
+	errLimitTime = putOrder(stub, &order)
+	if errLimitTime != nil {
+		return shim.Error(errLimitTime.Error())
+	}
+
 	end := time.Now()
 	elapsed := time.Since(start)
 	fmt.Println("time start: ", start.String())
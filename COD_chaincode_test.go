@@ -0,0 +1,1227 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/attrmgr"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//participant of the tests, roles are written to the cod.role attribute of its certificate
+type testUser struct {
+	mspID string
+	name  string
+	roles string
+}
+
+func (user testUser) id() string {
+	return user.mspID + "/" + user.name
+}
+
+var (
+	testAdmin    = testUser{"Org1MSP", "admin", "admin"}
+	testAdmin2   = testUser{"Org2MSP", "admin", "admin"}
+	testCustomer = testUser{"Org1MSP", "customer001", "customer"}
+	testSeller   = testUser{"Org1MSP", "seller001", "seller"}
+	testShipper  = testUser{"Org2MSP", "delivery001", "shipper"}
+	testStranger = testUser{"Org1MSP", "customer002", "customer,seller"}
+)
+
+func testAdminOf(user testUser) testUser {
+	if user.mspID == testAdmin2.mspID {
+		return testAdmin2
+	}
+	return testAdmin
+}
+
+//items of the test orders and the parcel that matches them
+const (
+	testItems  = `[{"asset":"Phone","quantity":2}]`
+	testParcel = `[{"asset":"Phone","variant":"","quantity":2,"unitprice":700}]`
+	testPrice  = 2*700 + 30
+	testFee    = 30
+	//stock of every seller and collateral of every shipper
+	testStock      = 20
+	testCollateral = 10000
+)
+
+//the mock stub of fabric has no creator, transient map, private deletes or private range queries,
+//testStub keeps them for the running transaction
+//like a peer with memberOnlyRead it refuses private reads to a creator whose msp is not in the collection policy,
+//outside of a transaction mspID is empty and the tests read everything, readErrors fails reads of a collection
+type testStub struct {
+	*shim.MockStub
+	args       [][]byte
+	creator    []byte
+	transient  map[string][]byte
+	mspID      string
+	members    map[string]map[string]bool
+	readErrors map[string]error
+}
+
+//members of every collection of collection.json
+func newTestStub(t *testing.T, cc *COD_chaincode) *testStub {
+	collectionsAsByte, err := ioutil.ReadFile("collection.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collections := []struct {
+		Name   string `json:"name"`
+		Policy string `json:"policy"`
+	}{}
+	err = json.Unmarshal(collectionsAsByte, &collections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := map[string]map[string]bool{}
+	for _, collection := range collections {
+		members[collection.Name] = map[string]bool{}
+		for _, member := range memberPattern.FindAllStringSubmatch(collection.Policy, -1) {
+			members[collection.Name][member[1]] = true
+		}
+	}
+	return &testStub{MockStub: shim.NewMockStub("COD", cc), members: members, readErrors: map[string]error{}}
+}
+
+var memberPattern = regexp.MustCompile(`'(\w+)\.member'`)
+
+//error of the peer for a read of a collection the creator's org is not a member of
+func (stub *testStub) checkRead(collection string) error {
+	if stub.readErrors[collection] != nil {
+		return stub.readErrors[collection]
+	}
+	if len(stub.mspID) == 0 || stub.members[collection][stub.mspID] {
+		return nil
+	}
+	return fmt.Errorf("tx creator does not have read access permission on privatedata in chaincodeName:%s collectionName: %s", stub.Name, collection)
+}
+
+func (stub *testStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *testStub) GetStringArgs() []string {
+	args := []string{}
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *testStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+func (stub *testStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+func (stub *testStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	err := stub.checkRead(collection)
+	if err != nil {
+		return nil, err
+	}
+	return stub.MockStub.GetPrivateData(collection, key)
+}
+
+func (stub *testStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+//like the peer a range never returns composite keys
+func (stub *testStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	err := stub.checkRead(collection)
+	if err != nil {
+		return nil, err
+	}
+	return stub.privateKeys(collection, func(key string) bool {
+		return !strings.HasPrefix(key, "\x00") && key >= startKey && (len(endKey) == 0 || key < endKey)
+	}), nil
+}
+
+func (stub *testStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	err := stub.checkRead(collection)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.privateKeys(collection, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}), nil
+}
+
+//couchdb queries with equality, $in, $gt and $or selectors over the documents of a collection in key order
+func (stub *testStub) GetPrivateDataQueryResult(collection string, query string) (shim.StateQueryIteratorInterface, error) {
+	err := stub.checkRead(collection)
+	if err != nil {
+		return nil, err
+	}
+	selector := struct {
+		Selector map[string]interface{} `json:"selector"`
+	}{}
+	err = json.Unmarshal([]byte(query), &selector)
+	if err != nil {
+		return nil, err
+	}
+	return stub.privateKeys(collection, func(key string) bool {
+		document := map[string]interface{}{}
+		if json.Unmarshal(stub.PvtState[collection][key], &document) != nil {
+			return false
+		}
+		document["_id"] = key
+		return matchSelector(document, selector.Selector)
+	}), nil
+}
+
+func matchSelector(document map[string]interface{}, selector map[string]interface{}) bool {
+	for field, condition := range selector {
+		if field == "$or" {
+			matched := false
+			for _, alternative := range condition.([]interface{}) {
+				matched = matched || matchSelector(document, alternative.(map[string]interface{}))
+			}
+			if !matched {
+				return false
+			}
+			continue
+		}
+		operators, ok := condition.(map[string]interface{})
+		if !ok {
+			if fmt.Sprint(document[field]) != fmt.Sprint(condition) {
+				return false
+			}
+			continue
+		}
+		for operator, value := range operators {
+			switch operator {
+			case "$gt":
+				if fmt.Sprint(document[field]) <= fmt.Sprint(value) {
+					return false
+				}
+			case "$in":
+				in := false
+				for _, item := range value.([]interface{}) {
+					in = in || fmt.Sprint(document[field]) == fmt.Sprint(item)
+				}
+				if !in {
+					return false
+				}
+			default:
+				panic("operator not supported by the test stub: " + operator)
+			}
+		}
+	}
+	return true
+}
+
+func (stub *testStub) privateKeys(collection string, match func(key string) bool) *testIterator {
+	keys := []string{}
+	for key := range stub.PvtState[collection] {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return &testIterator{stub.PvtState[collection], keys}
+}
+
+type testIterator struct {
+	values map[string][]byte
+	keys   []string
+}
+
+func (iter *testIterator) HasNext() bool {
+	return len(iter.keys) > 0
+}
+
+func (iter *testIterator) Next() (*queryresult.KV, error) {
+	if len(iter.keys) == 0 {
+		return nil, fmt.Errorf("iterator has no more keys")
+	}
+	key := iter.keys[0]
+	iter.keys = iter.keys[1:]
+	return &queryresult.KV{Key: key, Value: iter.values[key]}, nil
+}
+
+func (iter *testIterator) Close() error {
+	return nil
+}
+
+//serialized identity of user with a self signed certificate carrying its enrollment id and roles
+func testCreator(t *testing.T, user testUser) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := json.Marshal(attrmgr.Attributes{Attrs: map[string]string{"hf.EnrollmentID": user.name, roleAttribute: user.roles}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: user.name},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: attrmgr.AttrOID, Value: attrs}},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := &msp.SerializedIdentity{Mspid: user.mspID, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})}
+	creator, err := proto.Marshal(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+//chaincode on a mock stub, every invoke is its own transaction, clock moves the time of transactions
+type testNetwork struct {
+	t     *testing.T
+	cc    *COD_chaincode
+	stub  *testStub
+	txs   int
+	clock time.Duration
+}
+
+func newTestNetwork(t *testing.T, returnFee string) *testNetwork {
+	cc := new(COD_chaincode)
+	network := &testNetwork{t, cc, newTestStub(t, cc), 0, 0}
+
+	network.stub.args = [][]byte{[]byte("init"), []byte(returnFee)}
+	network.stub.MockTransactionStart("init")
+	response := cc.Init(network.stub)
+	network.stub.MockTransactionEnd("init")
+	if response.Status != shim.OK {
+		t.Fatalf("init: %s", response.Message)
+	}
+	return network
+}
+
+//call function as user, input is passed in the transient map when it is not nil
+func (network *testNetwork) invoke(user testUser, function string, input interface{}, args ...string) pb.Response {
+	network.stub.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		network.stub.args = append(network.stub.args, []byte(arg))
+	}
+	network.stub.transient = nil
+	if input != nil {
+		inputAsByte, err := json.Marshal(input)
+		if err != nil {
+			network.t.Fatal(err)
+		}
+		network.stub.transient = map[string][]byte{transientKey: inputAsByte}
+	}
+
+	response := pb.Response{}
+	network.transaction(user, func() {
+		response = network.cc.Invoke(network.stub)
+	})
+	return response
+}
+
+//run fn in a transaction created by user
+func (network *testNetwork) transaction(user testUser, fn func()) {
+	network.txs = network.txs + 1
+	txID := fmt.Sprintf("tx%04d", network.txs)
+
+	network.stub.creator = testCreator(network.t, user)
+	network.stub.mspID = user.mspID
+	defer func() {
+		network.stub.mspID = ""
+	}()
+	network.stub.MockTransactionStart(txID)
+	defer network.stub.MockTransactionEnd(txID)
+	txTime, err := ptypes.TimestampProto(time.Now().Add(network.clock))
+	if err != nil {
+		network.t.Fatal(err)
+	}
+	network.stub.TxTimestamp = txTime
+	fn()
+}
+
+func (network *testNetwork) mustInvoke(user testUser, function string, input interface{}, args ...string) []byte {
+	network.t.Helper()
+	response := network.invoke(user, function, input, args...)
+	if response.Status != shim.OK {
+		network.t.Fatalf("%s by %s: %s", function, user.id(), response.Message)
+	}
+	return response.Payload
+}
+
+//call that must be refused with a message containing message
+func (network *testNetwork) mustFail(message string, user testUser, function string, input interface{}, args ...string) {
+	network.t.Helper()
+	response := network.invoke(user, function, input, args...)
+	if response.Status == shim.OK {
+		network.t.Fatalf("%s by %s succeeded, want %q", function, user.id(), message)
+	}
+	if !strings.Contains(response.Message, message) {
+		network.t.Fatalf("%s by %s: %q, want %q", function, user.id(), response.Message, message)
+	}
+}
+
+//register the customer, the sellers with their stock of phones at 700 and the shippers with a fee of 30
+//every participant starts with a balance of 1000 given by the admin of its org
+func (network *testNetwork) setUp(sellers []testUser, shippers []testUser) {
+	network.t.Helper()
+	balances := map[string]bool{}
+	for _, user := range append([]testUser{testCustomer}, append(sellers, shippers...)...) {
+		if balances[user.id()] {
+			continue
+		}
+		balances[user.id()] = true
+		network.mustInvoke(testAdminOf(user), "createBalance", map[string]interface{}{"name": user.name, "balance": 1000, "kind": "balance"}, user.id())
+	}
+	for _, user := range shippers {
+		network.mustInvoke(testAdminOf(user), "createBalance", map[string]interface{}{"name": user.name, "balance": testCollateral, "kind": "mortgage"}, user.id())
+		network.mustInvoke(user, "createDelivery", map[string]interface{}{"name": user.name, "location": "Hanoi", "price": testFee, "distance": 10, "time": 2})
+	}
+	for _, user := range sellers {
+		network.mustInvoke(user, "createAsset", map[string]interface{}{"sellername": user.name, "asset": "Phone", "quantity": testStock, "price": 700})
+	}
+	network.mustInvoke(testCustomer, "createCustomer", map[string]interface{}{"name": "Customer", "location": "Hanoi", "number": "0123", "email": "customer@example.com"})
+}
+
+func (network *testNetwork) createOrder(seller testUser, shipper testUser) string {
+	network.t.Helper()
+	input := map[string]interface{}{"detail": "gift", "items": json.RawMessage(testItems), "amount": testPrice}
+	return string(network.mustInvoke(testCustomer, "createOrder", input, testCustomer.id(), seller.id(), shipper.id()))
+}
+
+//order of seller carried by shipper up to the status
+func (network *testNetwork) orderAt(seller testUser, shipper testUser, status string) string {
+	network.t.Helper()
+	id := network.createOrder(seller, shipper)
+	steps := []struct {
+		status   string
+		user     testUser
+		function string
+	}{
+		{StatusAccepted, shipper, "acceptOrder"},
+		{StatusPickedUp, shipper, "pickUpOrder"},
+		{StatusInTransit, shipper, "transitOrder"},
+	}
+	for _, step := range steps {
+		if network.order(id).Status == status {
+			break
+		}
+		network.mustInvoke(step.user, step.function, nil, id)
+	}
+	if network.order(id).Status != status {
+		network.t.Fatalf("order %s is %s, want %s", id, network.order(id).Status, status)
+	}
+	return id
+}
+
+func (network *testNetwork) order(id string) Order {
+	network.t.Helper()
+	order, err := getOrder(network.stub, id)
+	if err != nil {
+		network.t.Fatal(err)
+	}
+	return order
+}
+
+func (network *testNetwork) balance(user testUser) int {
+	network.t.Helper()
+	collection, err := orgBalanceCollection(network.stub, user.id())
+	if err != nil {
+		network.t.Fatal(err)
+	}
+	balance, err := getBalance(network.stub, collection, user.id())
+	if err != nil {
+		network.t.Fatal(err)
+	}
+	return balance.Balance
+}
+
+func (network *testNetwork) mortgage(user testUser) Balance {
+	network.t.Helper()
+	mortgage, err := getBalance(network.stub, "mortgageCollection", user.id())
+	if err != nil {
+		network.t.Fatal(err)
+	}
+	return mortgage
+}
+
+//pending transfers to user in the order they were made
+func (network *testNetwork) pending(user testUser) []Transfer {
+	network.t.Helper()
+	transfers := []Transfer{}
+	for key, value := range network.stub.PvtState["mortgageCollection"] {
+		transfer := Transfer{}
+		if !strings.HasPrefix(key, transferKey("")) {
+			continue
+		}
+		err := json.Unmarshal(value, &transfer)
+		if err != nil {
+			network.t.Fatal(err)
+		}
+		if transfer.To == user.id() && transfer.Status == TransferPending {
+			transfers = append(transfers, transfer)
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].TransferID < transfers[j].TransferID
+	})
+	return transfers
+}
+
+//user claims every transfer paid to it
+func (network *testNetwork) claim(user testUser) {
+	network.t.Helper()
+	for _, transfer := range network.pending(user) {
+		network.mustInvoke(user, "claimTransfer", nil, transfer.TransferID)
+	}
+}
+
+func (network *testNetwork) reserved(seller testUser) (int, int) {
+	network.t.Helper()
+	asset, err := getAsset(network.stub, seller.id(), "Phone")
+	if err != nil {
+		network.t.Fatal(err)
+	}
+	return asset.Quantity, asset.Reserved
+}
+
+func (network *testNetwork) checkBalance(user testUser, want int) {
+	network.t.Helper()
+	if balance := network.balance(user); balance != want {
+		network.t.Errorf("balance of %s is %d, want %d", user.id(), balance, want)
+	}
+}
+
+func (network *testNetwork) checkStock(seller testUser, quantity int, reserved int) {
+	network.t.Helper()
+	if q, r := network.reserved(seller); q != quantity || r != reserved {
+		network.t.Errorf("stock of %s is %d with %d reserved, want %d with %d reserved", seller.id(), q, r, quantity, reserved)
+	}
+}
+
+func (network *testNetwork) checkHeld(shipper testUser, balance int, held int) {
+	network.t.Helper()
+	if mortgage := network.mortgage(shipper); mortgage.Balance != balance || mortgage.Held != held {
+		network.t.Errorf("mortgage of %s is %d with %d held, want %d with %d held", shipper.id(), mortgage.Balance, mortgage.Held, balance, held)
+	}
+}
+
+func TestDeliveredOrder(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	id := network.createOrder(testSeller, testShipper)
+	if order := network.order(id); order.Status != StatusCreated || order.Price != testPrice || order.Fee != testFee {
+		t.Fatalf("new order is %s with price %d and fee %d", order.Status, order.Price, order.Fee)
+	}
+	network.checkStock(testSeller, testStock, 2)
+
+	network.mustInvoke(testSeller, "createAssetHash", nil, id)
+	network.mustInvoke(testShipper, "acceptOrder", nil, id)
+	network.checkHeld(testShipper, testCollateral, testPrice)
+	network.mustInvoke(testShipper, "pickUpOrder", nil, id)
+	network.mustInvoke(testShipper, "transitOrder", nil, id)
+
+	//the customer confirms a parcel the shipper verified against the seller's hash
+	network.mustFail("is not verified", testCustomer, "confirmDelivery", map[string]interface{}{"location": "Hanoi"}, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testItems), "location": "Hanoi"}, id)
+	network.mustFail("did not pass verifyShipper", testCustomer, "confirmDelivery", map[string]interface{}{"location": "Hanoi"}, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testParcel), "location": "Hanoi"}, id)
+	network.mustInvoke(testCustomer, "confirmDelivery", map[string]interface{}{"location": "Hanoi"}, id)
+
+	if status := network.order(id).Status; status != StatusSettled {
+		t.Errorf("delivered order is %s", status)
+	}
+	network.checkHeld(testShipper, testCollateral-testPrice+testFee, 0)
+	network.checkStock(testSeller, testStock-2, 0)
+	network.checkBalance(testSeller, 1000)
+	network.claim(testSeller)
+	network.checkBalance(testSeller, 1000+testPrice-testFee)
+	network.checkBalance(testShipper, 1000)
+
+	//every step is in the history in the order it happened
+	page := QueryPage{}
+	err := json.Unmarshal(network.mustInvoke(testCustomer, "getOrderHistory", nil, id), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []string{"createOrder", "createAssetHash", "acceptOrder", "pickUpOrder", "transitOrder", "verifyShipper", "verifyShipper", "confirmDelivery", "claimTransfer"}
+	if len(page.Records) != len(events) || network.order(id).Events != len(events) {
+		t.Fatalf("history has %d events and order counts %d, want %d", len(page.Records), network.order(id).Events, len(events))
+	}
+	for i, record := range page.Records {
+		event := OrderEvent{}
+		err = json.Unmarshal(record, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Sequence != i || event.Event != events[i] {
+			t.Errorf("event %d is %d %s, want %s", i, event.Sequence, event.Event, events[i])
+		}
+	}
+}
+
+func TestOrderTransitions(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	tests := []struct {
+		name     string
+		status   string
+		user     testUser
+		function string
+		allowed  bool
+	}{
+		{"pick up before accept", StatusCreated, testShipper, "pickUpOrder", false},
+		{"fail a created order", StatusCreated, testShipper, "failOrder", true},
+		{"fail an accepted order", StatusAccepted, testShipper, "failOrder", true},
+		{"fail a picked up order", StatusPickedUp, testShipper, "failOrder", false},
+		{"fail an order in transit", StatusInTransit, testShipper, "failOrder", false},
+		{"transit before pick up", StatusAccepted, testShipper, "transitOrder", false},
+		{"confirm return of an order in transit", StatusInTransit, testSeller, "confirmReturn", false},
+		{"settle an accepted order", StatusAccepted, testShipper, "settleReturn", false},
+	}
+
+	held := 0
+	reserved := 0
+	for _, test := range tests {
+		id := network.orderAt(testSeller, testShipper, test.status)
+		response := network.invoke(test.user, test.function, nil, id)
+		if (response.Status == shim.OK) != test.allowed {
+			t.Errorf("%s: status %d %s", test.name, response.Status, response.Message)
+		}
+		if !test.allowed && network.order(id).Status != test.status {
+			t.Errorf("%s: order moved to %s", test.name, network.order(id).Status)
+		}
+
+		status := network.order(id).Status
+		if status != StatusFailed {
+			reserved = reserved + 2
+		}
+		if status != StatusFailed && status != StatusCreated {
+			held = held + testPrice
+		}
+	}
+
+	//a failed order gives the shipper's collateral and the seller's goods back
+	network.checkHeld(testShipper, testCollateral, held)
+	network.checkStock(testSeller, testStock, reserved)
+}
+
+func TestCancelOrder(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "50")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	//before pick up cancelling is free and releases everything
+	id := network.orderAt(testSeller, testShipper, StatusAccepted)
+	network.mustFail("unknown cancel reason", testCustomer, "cancelOrder", nil, id, "customer", "BORED")
+	network.mustFail("cannot act for", testStranger, "cancelOrder", nil, id, "customer", "CHANGED_MIND")
+	network.mustInvoke(testCustomer, "cancelOrder", nil, id, "customer", "CHANGED_MIND")
+	if status := network.order(id).Status; status != StatusCancelled {
+		t.Errorf("order cancelled before pick up is %s", status)
+	}
+	network.checkHeld(testShipper, testCollateral, 0)
+	network.checkStock(testSeller, testStock, 0)
+	network.checkBalance(testSeller, 1000)
+	network.checkBalance(testShipper, 1000)
+
+	//after pick up the parcel goes back and the party pays the trip
+	id = network.orderAt(testSeller, testShipper, StatusInTransit)
+	network.mustInvoke(testSeller, "cancelOrder", nil, id, "seller", "OUT_OF_STOCK")
+	if status := network.order(id).Status; status != StatusReturning {
+		t.Errorf("order cancelled after pick up is %s", status)
+	}
+	network.checkBalance(testSeller, 1000-testFee)
+	network.claim(testShipper)
+	network.checkBalance(testShipper, 1000+testFee)
+	network.checkHeld(testShipper, testCollateral, testPrice)
+	network.checkStock(testSeller, testStock, 2)
+	if cancellation := network.order(id).Cancellation; cancellation == nil || cancellation.Stage != StatusInTransit || cancellation.Fee != testFee {
+		t.Errorf("cancellation %+v", cancellation)
+	}
+
+	network.mustInvoke(testSeller, "confirmReturn", nil, id)
+	network.checkStock(testSeller, testStock, 0)
+
+	//the shipper settles alone, the return fee is only charged for refusals
+	network.mustFail("cannot act for", testStranger, "settleReturn", nil, id)
+	network.mustInvoke(testShipper, "settleReturn", nil, id)
+	if status := network.order(id).Status; status != StatusSettled {
+		t.Errorf("settled return is %s", status)
+	}
+	network.checkHeld(testShipper, testCollateral, 0)
+	network.checkBalance(testSeller, 1000-testFee)
+	network.checkBalance(testShipper, 1000+testFee)
+
+	//a delivered order next to the cancelled ones, only it counts as delivered
+	id = network.orderAt(testSeller, testShipper, StatusInTransit)
+	network.mustInvoke(testSeller, "createAssetHash", nil, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testParcel), "location": "Hanoi"}, id)
+	network.mustInvoke(testCustomer, "confirmDelivery", map[string]interface{}{"location": "Hanoi"}, id)
+
+	sellerReport := SellerReport{}
+	err := json.Unmarshal(network.mustInvoke(testSeller, "sellerReport", nil, testSeller.id(), "", ""), &sellerReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sellerReport.Orders != 3 || sellerReport.Cancelled != 2 || sellerReport.Delivered != 1 || sellerReport.Revenue != testPrice-testFee {
+		t.Errorf("seller report %+v", sellerReport)
+	}
+	shipperReport := ShipperReport{}
+	err = json.Unmarshal(network.mustInvoke(testShipper, "shipperReport", nil, testShipper.id(), "", ""), &shipperReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shipperReport.Cancelled != 2 || shipperReport.Delivered != 1 || shipperReport.CollectedCOD != testPrice || shipperReport.FeesEarned != 2*testFee {
+		t.Errorf("shipper report %+v", shipperReport)
+	}
+}
+
+func TestRefusedDelivery(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "50")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	id := network.orderAt(testSeller, testShipper, StatusInTransit)
+	network.mustFail("location of refusal", testShipper, "refuseDelivery", map[string]interface{}{"reason": "not home"}, id)
+	network.mustInvoke(testShipper, "refuseDelivery", map[string]interface{}{"location": "Hanoi", "reason": "not home"}, id)
+	network.mustFail("not confirmed by the seller", testSeller, "settleReturn", nil, id)
+	network.mustFail("not confirmed by the seller", testShipper, "settleReturn", nil, id)
+	network.mustInvoke(testSeller, "confirmReturn", nil, id)
+	network.mustInvoke(testSeller, "settleReturn", nil, id)
+
+	if order := network.order(id); order.Status != StatusSettled || order.Refusal == nil {
+		t.Errorf("refused order is %s with refusal %v", order.Status, order.Refusal)
+	}
+	network.checkBalance(testSeller, 1000-50)
+	network.claim(testShipper)
+	network.checkBalance(testShipper, 1000+50)
+	network.checkHeld(testShipper, testCollateral, 0)
+	network.checkStock(testSeller, testStock, 0)
+}
+
+//a seller who does not confirm a return cannot keep the shipper's collateral held
+func TestReturnTimeout(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "50")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	id := network.orderAt(testSeller, testShipper, StatusInTransit)
+	network.mustInvoke(testShipper, "refuseDelivery", map[string]interface{}{"location": "Hanoi", "reason": "not home"}, id)
+	network.clock = returnTimeout - time.Hour
+	network.mustFail("not confirmed by the seller", testShipper, "settleReturn", nil, id)
+
+	network.clock = returnTimeout + time.Hour
+	network.mustInvoke(testShipper, "settleReturn", nil, id)
+	network.checkHeld(testShipper, testCollateral, 0)
+	if status := network.order(id).Status; status != StatusReturning {
+		t.Errorf("order settled after the timeout is %s", status)
+	}
+	network.checkStock(testSeller, testStock, 2)
+
+	//the seller still takes the goods back and pays the trip
+	network.mustInvoke(testSeller, "confirmReturn", nil, id)
+	network.mustInvoke(testShipper, "settleReturn", nil, id)
+	if status := network.order(id).Status; status != StatusSettled {
+		t.Errorf("confirmed return is %s", status)
+	}
+	network.checkStock(testSeller, testStock, 0)
+	network.checkBalance(testSeller, 1000-50)
+	network.checkHeld(testShipper, testCollateral, 0)
+}
+
+func TestAssetPrices(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	network.mustFail("price must not be negative", testSeller, "createAsset", map[string]interface{}{"sellername": "Shop", "asset": "Case", "quantity": 1, "price": -1})
+	network.mustFail("quantity must not be negative", testSeller, "createAsset", map[string]interface{}{"sellername": "Shop", "asset": "Case", "quantity": -1, "price": 1})
+	network.mustFail("price must not be negative", testSeller, "updateAsset", map[string]interface{}{"quantity": 1, "price": -700}, testSeller.id(), "Phone")
+	network.mustFail("price must not be negative", testShipper, "createDelivery", map[string]interface{}{"name": "d", "location": "Hanoi", "price": -30, "distance": 10, "time": 2})
+	network.mustFail("must not be negative", testShipper, "createDelivery", map[string]interface{}{"name": "d", "location": "Hanoi", "price": 30, "distance": -10, "time": 2})
+
+	//a free asset would make an order line worth nothing
+	network.mustInvoke(testSeller, "createAsset", map[string]interface{}{"sellername": "Shop", "asset": "Sticker", "quantity": 5, "price": 0})
+	input := map[string]interface{}{"detail": "gift", "items": json.RawMessage(`[{"asset":"Sticker","quantity":1}]`)}
+	network.mustFail("total of line Sticker must be positive", testCustomer, "createOrder", input, testCustomer.id(), testSeller.id(), testShipper.id())
+
+	input = map[string]interface{}{"detail": "gift", "items": json.RawMessage(testItems), "amount": testPrice - 1}
+	network.mustFail("does not match price of order", testCustomer, "createOrder", input, testCustomer.id(), testSeller.id(), testShipper.id())
+	network.checkStock(testSeller, testStock, 0)
+}
+
+func TestOrderDetails(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	network.mustFail("order does not exist or cannot be read", testCustomer, "getOrderDetails", nil, "missing")
+
+	id := network.orderAt(testSeller, testShipper, StatusPickedUp)
+	network.mustInvoke(testSeller, "createAssetHash", nil, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testParcel), "location": "Hanoi"}, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testItems), "location": "Hai Phong"}, id)
+
+	//parts of the timeline in a collection the caller's org cannot read are left out
+	details := OrderDetails{}
+	err := json.Unmarshal(network.mustInvoke(testCustomer, "getOrderDetails", nil, id), &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Order == nil || details.OrderHash != nil || details.LimitTime != nil {
+		t.Fatalf("details read by the customer %+v", details)
+	}
+	details = OrderDetails{}
+	err = json.Unmarshal(network.mustInvoke(testShipper, "getOrderDetails", nil, id), &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Order == nil || details.OrderHash == nil || details.LimitTime != nil {
+		t.Fatalf("details read by the shipper %+v", details)
+	}
+	statuses := map[string]string{}
+	for _, verify := range details.VerifyShipper {
+		statuses[verify.Location] = verify.Status
+	}
+	if len(statuses) != 2 || statuses["Hanoi"] != "verify successul" || statuses["Hai Phong"] != "verify failed" {
+		t.Errorf("verifications %+v", details.VerifyShipper)
+	}
+
+	if !isAccessDenied(fmt.Errorf("tx creator does not have read access permission on privatedata in chaincodeName:COD collectionName: limitTimeCollection")) {
+		t.Errorf("read access error of the peer is not recognized")
+	}
+}
+
+func TestTransferMoney(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	network.mustInvoke(testCustomer, "transferMoney", map[string]interface{}{"amount": 100}, testCustomer.id(), testSeller.id())
+	network.checkBalance(testCustomer, 900)
+	network.checkBalance(testSeller, 1100)
+
+	network.mustFail("to itself", testCustomer, "transferMoney", map[string]interface{}{"amount": 100}, testCustomer.id(), testCustomer.id())
+	network.mustFail("enough balance", testCustomer, "transferMoney", map[string]interface{}{"amount": 901}, testCustomer.id(), testSeller.id())
+	network.mustFail("amount must be a positive number", testCustomer, "transferMoney", map[string]interface{}{"amount": -5}, testCustomer.id(), testSeller.id())
+	network.mustFail("cannot act for", testCustomer, "transferMoney", map[string]interface{}{"amount": 100}, testSeller.id(), testCustomer.id())
+	network.mustFail("different orgs", testCustomer, "transferMoney", map[string]interface{}{"amount": 100}, testCustomer.id(), testShipper.id())
+	network.checkBalance(testCustomer, 900)
+	network.checkBalance(testSeller, 1100)
+
+	//a transfer is only written to the history of an order the caller takes part in
+	id := network.createOrder(testSeller, testShipper)
+	network.mustFail("cannot act for", testStranger, "transferMoney", map[string]interface{}{"amount": 1}, testStranger.id(), testCustomer.id(), id)
+	network.mustInvoke(testCustomer, "transferMoney", map[string]interface{}{"amount": 10}, testCustomer.id(), testSeller.id(), id)
+	if events := network.order(id).Events; events != 2 {
+		t.Errorf("order has %d events, want 2", events)
+	}
+}
+
+//order payments wait in transfers the payee claims, each transaction only touches the balances of the caller's org
+func TestOrderPayments(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "50")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	//cancelled after pick up, the seller pays the trip
+	id := network.orderAt(testSeller, testShipper, StatusPickedUp)
+	network.mustInvoke(testSeller, "cancelOrder", nil, id, "seller", "OUT_OF_STOCK")
+	network.checkBalance(testSeller, 1000-testFee)
+	network.checkBalance(testShipper, 1000)
+	transfers := network.pending(testShipper)
+	if len(transfers) != 1 || transfers[0].Amount != testFee || transfers[0].OrderID != id || !transfers[0].Payment {
+		t.Fatalf("transfers of the cancel fee %+v", transfers)
+	}
+	network.mustFail("cannot be cancelled", testSeller, "cancelTransfer", nil, transfers[0].TransferID)
+	network.mustInvoke(testShipper, "claimTransfer", nil, transfers[0].TransferID)
+	network.checkBalance(testShipper, 1000+testFee)
+	network.mustInvoke(testSeller, "confirmReturn", nil, id)
+	network.mustInvoke(testShipper, "settleReturn", nil, id)
+	if transfers := network.pending(testShipper); len(transfers) > 0 {
+		t.Errorf("return fee charged for a cancelled order %+v", transfers)
+	}
+
+	//refused parcel, the seller pays the return fee when it confirms the return
+	id = network.orderAt(testSeller, testShipper, StatusInTransit)
+	network.mustInvoke(testShipper, "refuseDelivery", map[string]interface{}{"location": "Hanoi", "reason": "not home"}, id)
+	network.mustInvoke(testSeller, "confirmReturn", nil, id)
+	network.mustInvoke(testShipper, "settleReturn", nil, id)
+	network.checkBalance(testSeller, 1000-testFee-50)
+	network.claim(testShipper)
+	network.checkBalance(testShipper, 1000+testFee+50)
+
+	//delivered parcel, the collateral pays the seller's share and the shipper keeps the fee out of the cash
+	id = network.orderAt(testSeller, testShipper, StatusInTransit)
+	network.mustInvoke(testSeller, "createAssetHash", nil, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testParcel), "location": "Hanoi"}, id)
+	network.mustInvoke(testShipper, "confirmDelivery", map[string]interface{}{"location": "Hanoi"}, id)
+	network.checkHeld(testShipper, testCollateral-testPrice+testFee, 0)
+	network.checkBalance(testShipper, 1000+testFee+50)
+	network.claim(testSeller)
+	network.checkBalance(testSeller, 1000-testFee-50+testPrice-testFee)
+
+	//a participant paying itself moves nothing
+	network.transaction(testSeller, func() {
+		order := network.order(id)
+		transferID, err := payOrder(network.stub, &order, testSeller.id(), testSeller.id(), 100, "test")
+		if err != nil || len(transferID) > 0 {
+			t.Errorf("payment to itself: transfer %q, %v", transferID, err)
+		}
+	})
+	network.checkBalance(testSeller, 1000-testFee-50+testPrice-testFee)
+
+	network.mustFail("not a balance collection", testAdmin, "setBalanceCollection", nil, "Org1MSP", "mortgageCollection")
+	network.mustFail("not a balance collection", testAdmin, "setBalanceCollection", nil, "Org1MSP", "orderCollection")
+}
+
+//shippers of Org2 run every step of their orders, non members of a collection cannot read it
+func TestCollectionMembership(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	id := network.orderAt(testSeller, testShipper, StatusInTransit)
+	order := Order{}
+	err := json.Unmarshal(network.mustInvoke(testShipper, "getOrder", nil, id), &Record{Data: &order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderID != id || order.Delivery != testShipper.id() {
+		t.Errorf("order read by the shipper %+v", order)
+	}
+
+	network.stub.mspID = testCustomer.mspID
+	_, err = network.stub.GetPrivateData("balanceOrg2Collection", testShipper.id())
+	network.stub.mspID = ""
+	if err == nil || !isAccessDenied(err) {
+		t.Errorf("customer reads balanceOrg2Collection: %v", err)
+	}
+}
+//the whole route is ranked before it is paged, following the bookmarks gives every shipper once in rank order
+func TestQuoteDelivery(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+
+	shippers := []struct {
+		name     string
+		location string
+		price    int
+	}{
+		{"delivery011", "Hai Phong", 10},
+		{"delivery012", "Hanoi", 40},
+		{"delivery013", "Da Nang", 5},
+		{"delivery014", "Hanoi", 20},
+		{"delivery015", "Hai Phong", 30},
+		{"delivery016", "Hanoi", 20},
+	}
+	for _, shipper := range shippers {
+		user := testUser{"Org2MSP", shipper.name, "shipper"}
+		network.mustInvoke(user, "createDelivery", map[string]interface{}{"name": shipper.name, "location": shipper.location, "price": shipper.price, "distance": 10, "time": 2})
+	}
+
+	want := []string{"delivery014", "delivery016", "delivery012", "delivery011", "delivery015"}
+	names := []string{}
+	bookmark := ""
+	for pages := 0; pages < 5; pages++ {
+		page := QueryPage{}
+		err := json.Unmarshal(network.mustInvoke(testCustomer, "quoteDelivery", nil, "Hanoi", "Hai Phong", "2", bookmark), &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range page.Records {
+			quote := DeliveryQuote{}
+			err = json.Unmarshal(record, &quote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, strings.TrimPrefix(quote.Name, "Org2MSP/"))
+		}
+		bookmark = page.Bookmark
+		if len(bookmark) == 0 {
+			break
+		}
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("quotes %v, want %v", names, want)
+	}
+}
+
+func TestDecodeDelivery(t *testing.T) {
+	tests := []struct {
+		json     string
+		distance int
+		time     int
+		fails    bool
+	}{
+		{`{"name":"d","distance":10,"time":2}`, 10, 2, false},
+		{`{"name":"d","distance":"10","time":" 2 "}`, 10, 2, false},
+		{`{"name":"d","distance":"","time":null}`, 0, 0, false},
+		{`{"name":"d"}`, 0, 0, false},
+		{`{"name":"d","distance":"far"}`, 0, 0, true},
+		{`{"name":"d","time":true}`, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		delivery := Delivery{}
+		err := json.Unmarshal([]byte(test.json), &delivery)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.json)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.json, err.Error())
+			continue
+		}
+		if delivery.Name != "d" || delivery.Distance != test.distance || delivery.Time != test.time {
+			t.Errorf("%s: %+v", test.json, delivery)
+		}
+	}
+}
+
+//a verification the shipper's org may not read is left out of its report, other read errors fail the report
+func TestShipperReportErrors(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+	id := network.orderAt(testSeller, testShipper, StatusPickedUp)
+	network.mustInvoke(testSeller, "createAssetHash", nil, id)
+	network.mustInvoke(testShipper, "verifyShipper", map[string]interface{}{"items": json.RawMessage(testItems), "location": "Hanoi"}, id)
+
+	report := ShipperReport{}
+	err := json.Unmarshal(network.mustInvoke(testShipper, "shipperReport", nil, testShipper.id(), "", ""), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FailedVerifications != 1 {
+		t.Errorf("report %+v", report)
+	}
+
+	network.stub.readErrors["verifyShipperCollection"] = fmt.Errorf("tx creator does not have read access permission on privatedata in chaincodeName:COD collectionName: verifyShipperCollection")
+	report = ShipperReport{}
+	err = json.Unmarshal(network.mustInvoke(testShipper, "shipperReport", nil, testShipper.id(), "", ""), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FailedVerifications != 0 {
+		t.Errorf("report without verifications %+v", report)
+	}
+
+	network.stub.readErrors["verifyShipperCollection"] = fmt.Errorf("couchdb is not reachable")
+	network.mustFail("couchdb is not reachable", testShipper, "shipperReport", nil, testShipper.id(), "", "")
+}
+
+//rows left out by the docType filter count against the scan of a page, the bookmark continues after them
+func TestExportScanLimit(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.stub.PvtState["customerCollection"] = map[string][]byte{}
+	for i := 0; i < maxScannedRows+5; i++ {
+		network.stub.PvtState["customerCollection"][fmt.Sprintf("A%05d", i)] = []byte(`{"docType":"Note"}`)
+	}
+	network.stub.PvtState["customerCollection"]["Z"] = []byte(`{"docType":"Customer","name":"Z"}`)
+
+	page := QueryPage{}
+	err := json.Unmarshal(network.mustInvoke(testAdmin, "exportCollection", nil, "customerCollection", "Customer"), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Records) != 0 || len(page.Bookmark) == 0 {
+		t.Fatalf("first page has %d records and bookmark %q", len(page.Records), page.Bookmark)
+	}
+	bookmark := page.Bookmark
+	page = QueryPage{}
+	err = json.Unmarshal(network.mustInvoke(testAdmin, "exportCollection", nil, "customerCollection", "Customer", "", bookmark), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Records) != 1 || len(page.Bookmark) > 0 {
+		t.Errorf("second page has %d records and bookmark %q", len(page.Records), page.Bookmark)
+	}
+}
+
+func TestOwnerChecks(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+	id := network.orderAt(testSeller, testShipper, StatusAccepted)
+	otherShipper := testUser{"Org2MSP", "delivery002", "shipper"}
+
+	tests := []struct {
+		name     string
+		user     testUser
+		function string
+		input    interface{}
+		args     []string
+	}{
+		{"order of someone else", testStranger, "getOrder", nil, []string{id}},
+		{"history of someone else's order", testStranger, "getOrderHistory", nil, []string{id}},
+		{"details of someone else's order", testStranger, "getOrderDetails", nil, []string{id}},
+		{"order of another shipper", otherShipper, "getOrder", nil, []string{id}},
+		{"other seller hashes the order", testStranger, "createAssetHash", nil, []string{id}},
+		{"balance of someone else", testStranger, "getBalance", nil, []string{testSeller.id(), "balance"}},
+		{"shipper reads a seller's balance", testShipper, "getBalance", nil, []string{testSeller.id(), "balance"}},
+		{"report of someone else", testStranger, "sellerReport", nil, []string{testSeller.id(), "", ""}},
+		{"shipper report of someone else", otherShipper, "shipperReport", nil, []string{testShipper.id(), "", ""}},
+		{"collateral of another shipper", otherShipper, "queryCollateral", nil, []string{testShipper.id()}},
+		{"orders of another customer", testStranger, "queryOrdersByCustomer", nil, []string{testCustomer.id()}},
+		{"orders of another shipper", testSeller, "queryOrdersByShipper", nil, []string{testShipper.id()}},
+		{"other shipper picks up", otherShipper, "pickUpOrder", nil, []string{id}},
+		{"asset of someone else", testStranger, "updateAsset", map[string]interface{}{"quantity": 1, "price": 1}, []string{testSeller.id(), "Phone"}},
+		{"order for another customer", testStranger, "createOrder", map[string]interface{}{"detail": "", "items": json.RawMessage(testItems)}, []string{testCustomer.id(), testSeller.id(), testShipper.id()}},
+	}
+	for _, test := range tests {
+		network.mustFail("cannot act for", test.user, test.function, test.input, test.args...)
+	}
+
+	//every party reads the order
+	for _, user := range []testUser{testCustomer, testSeller, testShipper} {
+		network.mustInvoke(user, "getOrder", nil, id)
+		network.mustInvoke(user, "getOrderHistory", nil, id)
+		network.mustInvoke(user, "getOrderDetails", nil, id)
+	}
+}
+
+//the seller and shipper of a limit time come from the stored order, not from the caller
+func TestDealLimitTimeParties(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	id := network.createOrder(testSeller, testShipper)
+	input := map[string]interface{}{"limittime": "18:00", "day": "2"}
+	network.mustFail("order does not exist", testSeller, "dealLimitTime", input, "missing")
+	network.mustFail("cannot act for", testStranger, "dealLimitTime", input, id)
+	network.mustInvoke(testSeller, "dealLimitTime", input, id)
+
+	details := OrderDetails{}
+	err := json.Unmarshal(network.mustInvoke(testCustomer, "getOrderDetails", nil, id), &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limitTime := details.LimitTime
+	if limitTime == nil || limitTime.SellerID != testSeller.id() || limitTime.DeliveryID != testShipper.id() || limitTime.Time != "18:00" || limitTime.Day != "2" {
+		t.Errorf("limit time %+v", limitTime)
+	}
+	if details.Order == nil || details.Order.Events != 2 {
+		t.Errorf("order of details %+v", details.Order)
+	}
+}
+
+//roles are tied to the orgs that may hold them and admins only act for their own org
+func TestRoleChecks(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+	id := network.orderAt(testSeller, testShipper, StatusAccepted)
+
+	tests := []struct {
+		name     string
+		message  string
+		user     testUser
+		function string
+		input    interface{}
+		args     []string
+	}{
+		{"customer creates a balance", "cannot call createBalance", testCustomer, "createBalance", map[string]interface{}{"name": "c", "balance": 1, "kind": "balance"}, nil},
+		{"caller without role", "has no cod.role attribute", testUser{"Org1MSP", "nobody", ""}, "getOrder", nil, []string{id}},
+		{"unknown role", "unknown role", testUser{"Org1MSP", "nobody", "owner"}, "getOrder", nil, []string{id}},
+		{"seller accepts an order", "cannot call acceptOrder", testSeller, "acceptOrder", nil, []string{id}},
+		{"shipper enrolled by the sellers' org", "cannot be held by a member of Org1MSP", testUser{"Org1MSP", "delivery003", "shipper"}, "acceptOrder", nil, []string{id}},
+		{"customer enrolled by the shippers' org", "cannot be held by a member of Org2MSP", testUser{"Org2MSP", "customer003", "customer"}, "getOrder", nil, []string{id}},
+		{"admin of the shippers' org gives a seller balance", "cannot act for", testAdmin2, "createBalance", map[string]interface{}{"name": "s", "balance": 1, "kind": "balance"}, []string{"Org1MSP/seller003"}},
+		{"admin of the shippers' org reads a seller's balance", "cannot act for", testAdmin2, "getBalance", nil, []string{testSeller.id(), "balance"}},
+		{"admin of the sellers' org reads a shipper's collateral", "cannot act for", testAdmin, "queryCollateral", nil, []string{testShipper.id()}},
+		{"admin moves the balances of the other org", "cannot change the balance collection of Org1MSP", testAdmin2, "setBalanceCollection", nil, []string{"Org1MSP", "balanceOrg2Collection"}},
+	}
+	for _, test := range tests {
+		response := network.invoke(test.user, test.function, test.input, test.args...)
+		if response.Status == shim.OK || !strings.Contains(response.Message, test.message) {
+			t.Errorf("%s: status %d %q, want %q", test.name, response.Status, response.Message, test.message)
+		}
+	}
+
+	//admins act for the parties of their own org
+	network.mustInvoke(testAdmin, "getOrder", nil, id)
+	network.mustInvoke(testAdmin2, "getOrder", nil, id)
+	network.mustInvoke(testAdmin, "getBalance", nil, testSeller.id(), "balance")
+	network.mustInvoke(testAdmin2, "queryCollateral", nil, testShipper.id())
+
+	//shippers do not get the contact of customers
+	customers := []struct {
+		user     testUser
+		redacted bool
+	}{
+		{testSeller, false},
+		{testShipper, true},
+		{testAdmin2, true},
+		{testAdmin, false},
+	}
+	for _, test := range customers {
+		customer := Customer{}
+		record := Record{Data: &customer}
+		err := json.Unmarshal(network.mustInvoke(test.user, "getCustomer", nil, testCustomer.id()), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(customer.Number) == 0) != test.redacted || (len(record.Redacted) > 0) != test.redacted {
+			t.Errorf("customer read by %s: %+v redacted %v", test.user.id(), customer, record.Redacted)
+		}
+	}
+}
+
+//the limit time and day of dealLimitTime are private and only read from the transient input
+func TestDealLimitTimeInput(t *testing.T) {
+	t.Parallel()
+	network := newTestNetwork(t, "")
+	network.setUp([]testUser{testSeller}, []testUser{testShipper})
+
+	id := network.createOrder(testSeller, testShipper)
+	network.mustFail("transient map", testSeller, "dealLimitTime", nil, id)
+	network.mustFail("expecting 1 argument", testSeller, "dealLimitTime", map[string]interface{}{"limittime": "18:00", "day": "2"}, id, "18:00", "2")
+	network.mustFail("day", testSeller, "dealLimitTime", map[string]interface{}{"limittime": "18:00"}, id)
+	network.mustInvoke(testSeller, "dealLimitTime", map[string]interface{}{"limittime": "18:00", "day": "2"}, id)
+	if _, ok := network.stub.PvtState["limitTimeCollection"][id]; !ok {
+		t.Errorf("limit time of order %s is not saved", id)
+	}
+}
@@ -0,0 +1,188 @@
+// Command codexport dumps the documents of one docType from a private data collection of
+// the COD chaincode, for reconciliation with accounting and for backups before the
+// collection's blockToLive purges them.
+//
+// It pages through the exportCollection query with peer chaincode query and writes
+// every document as a json line, or as csv with one column per field. Documents keep the
+// key they are stored under, fields holding objects or lists are written as json in csv.
+//
+//	codexport -collection orderCollection -doctype Order -format csv -o orders.csv
+//	codexport -collection balanceOrg1Collection -doctype Balance > balances.jsonl
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//one page of exportCollection
+type queryPage struct {
+	Records             []json.RawMessage `json:"records"`
+	FetchedRecordsCount int               `json:"fetchedrecordscount"`
+	Bookmark            string            `json:"bookmark"`
+}
+
+//one exported document
+type record struct {
+	DocType  string                     `json:"docType"`
+	Key      string                     `json:"key"`
+	Redacted []string                   `json:"redacted,omitempty"`
+	Data     map[string]json.RawMessage `json:"data"`
+}
+
+func main() {
+	channel := flag.String("channel", "mychannel", "channel of the chaincode")
+	chaincode := flag.String("chaincode", "COD", "name of the chaincode")
+	collection := flag.String("collection", "", "collection to export, like orderCollection")
+	docType := flag.String("doctype", "", "docType of the documents to export, like Order")
+	format := flag.String("format", "jsonl", "output format, jsonl or csv")
+	output := flag.String("o", "", "output file, standard output when empty")
+	pageSize := flag.Int("page-size", 100, "documents fetched per query")
+	peerBinary := flag.String("peer", "peer", "peer binary")
+	peerFlags := flag.String("peer-flags", "", "extra flags for peer chaincode query, like tls flags")
+	flag.Parse()
+
+	if len(*collection) == 0 || len(*docType) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: codexport -collection name -doctype type [flags]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if *format != "jsonl" && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "format must be jsonl or csv")
+		os.Exit(2)
+	}
+
+	records := []record{}
+	bookmark := ""
+	for {
+		page, err := queryExport(*peerBinary, *peerFlags, *channel, *chaincode, *collection, *docType, *pageSize, bookmark)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		for _, raw := range page.Records {
+			r := record{}
+			err = json.Unmarshal(raw, &r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cannot unmarshal record: %s\n", err.Error())
+				os.Exit(1)
+			}
+			records = append(records, r)
+		}
+		if len(page.Bookmark) == 0 {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	var out io.Writer = os.Stdout
+	if len(*output) > 0 {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var err error
+	if *format == "csv" {
+		err = writeCSV(out, records)
+	} else {
+		err = writeJSONLines(out, records)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d %s exported from %s\n", len(records), *docType, *collection)
+}
+
+//run one exportCollection query through the peer cli
+func queryExport(peerBinary string, peerFlags string, channel string, chaincode string, collection string, docType string, pageSize int, bookmark string) (queryPage, error) {
+	ctor := map[string][]string{"Args": {"exportCollection", collection, docType, strconv.Itoa(pageSize), bookmark}}
+	ctorAsByte, err := json.Marshal(ctor)
+	if err != nil {
+		return queryPage{}, err
+	}
+
+	args := []string{"chaincode", "query"}
+	args = append(args, strings.Fields(os.ExpandEnv(peerFlags))...)
+	args = append(args, "-C", channel, "-n", chaincode, "-c", string(ctorAsByte))
+	command := exec.Command(peerBinary, args...)
+	command.Stderr = os.Stderr
+	pageAsByte, err := command.Output()
+	if err != nil {
+		return queryPage{}, fmt.Errorf("peer chaincode query failed: %s", err.Error())
+	}
+
+	page := queryPage{}
+	err = json.Unmarshal(pageAsByte, &page)
+	if err != nil {
+		return queryPage{}, fmt.Errorf("cannot unmarshal page: %s", err.Error())
+	}
+	return page, nil
+}
+
+//one json document per line, as returned by the chaincode
+func writeJSONLines(out io.Writer, records []record) error {
+	writer := bufio.NewWriter(out)
+	for _, r := range records {
+		recordAsByte, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		writer.Write(recordAsByte)
+		writer.WriteString("\n")
+	}
+	return writer.Flush()
+}
+
+//key column then one column per field of the documents, in name order
+func writeCSV(out io.Writer, records []record) error {
+	fieldSet := map[string]bool{}
+	for _, r := range records {
+		for field := range r.Data {
+			fieldSet[field] = true
+		}
+	}
+	fields := []string{}
+	for field := range fieldSet {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	writer := csv.NewWriter(out)
+	writer.Write(append([]string{"key"}, fields...))
+	for _, r := range records {
+		row := []string{r.Key}
+		for _, field := range fields {
+			row = append(row, csvValue(r.Data[field]))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//strings are written bare, numbers and booleans as is, objects and lists as json
+func csvValue(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	text := ""
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	return string(raw)
+}
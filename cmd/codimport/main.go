@@ -0,0 +1,150 @@
+// Command codimport loads test data into the COD chaincode.
+//
+// It reads xlsx workbooks such as data_CoD_trieu.xlsx and csv files, checks every row
+// with the same rules as createCustomer, createAsset, createDelivery, createBalance and
+// createOrder, and prints one peer chaincode invoke command per valid row. Names, contacts,
+// amounts and items go in the input field of the transient map so they never reach a block,
+// only identities are passed as args. With -submit the commands are run instead of printed.
+// Rows that fail are reported with their sheet and row number and make the command exit
+// with status 1.
+//
+// In a workbook a block of rows starts at a cell naming the function, like createOrder(),
+// with the headers on the same row. A csv file, or a sheet without such cells, has a
+// header row and names the function in a function column or with -function. Rows of
+// createOrder with the same orderID become one order with several lines. Participants are
+// registered under the identity submitting the rows unless an identity column, like
+// Org1MSP/seller001, names another one, which only admins of that msp may do. Ids without an msp, like
+// the seller001 of the bundled workbook, get the msp of their role from -customer-msp,
+// -seller-msp and -shipper-msp, balances of a named org get that org's msp.
+//
+//	codimport -channel mychannel data_CoD_trieu.xlsx
+//	codimport -function createAsset -submit -peer-flags "-o orderer.example.com:7050 --tls --cafile $ORDERER_CA" assets.csv
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	channel := flag.String("channel", "mychannel", "channel of the chaincode")
+	chaincode := flag.String("chaincode", "COD", "name of the chaincode")
+	function := flag.String("function", "", "function of rows in sheets without a function cell or column")
+	peerBinary := flag.String("peer", "peer", "peer binary used with -submit")
+	peerFlags := flag.String("peer-flags", "", "extra flags for peer chaincode invoke, like orderer and tls flags")
+	submit := flag.Bool("submit", false, "run the invocations instead of printing them")
+	customerMSP := flag.String("customer-msp", "Org1MSP", "msp id of customers named by enrollment id only")
+	sellerMSP := flag.String("seller-msp", "Org1MSP", "msp id of sellers named by enrollment id only")
+	shipperMSP := flag.String("shipper-msp", "Org2MSP", "msp id of shippers named by enrollment id only")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: codimport [flags] file.xlsx|file.csv ...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, fileName := range flag.Args() {
+		var sheets []sheet
+		var err error
+		switch strings.ToLower(filepath.Ext(fileName)) {
+		case ".xlsx":
+			sheets, err = readXLSX(fileName)
+		case ".csv":
+			sheets, err = readCSV(fileName)
+		default:
+			err = fmt.Errorf("only .xlsx and .csv files can be imported")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", fileName, err.Error())
+			failed = true
+			continue
+		}
+
+		for _, s := range sheets {
+			records, notes := extractRecords(s, *function)
+			invocations, errors, skipped := buildInvocations(records, orgs{*customerMSP, *sellerMSP, *shipperMSP})
+			for _, note := range append(notes, skipped...) {
+				fmt.Fprintln(os.Stderr, "note: "+note)
+			}
+			for _, rowError := range errors {
+				fmt.Fprintln(os.Stderr, "error: "+rowError)
+				failed = true
+			}
+
+			for _, inv := range invocations {
+				peerArgs, err := invokeArgs(inv, *channel, *chaincode, *peerFlags)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %s: %s\n", inv.Source, err.Error())
+					failed = true
+					continue
+				}
+				if !*submit {
+					fmt.Println(shellCommand(*peerBinary, peerArgs))
+					continue
+				}
+
+				output, err := exec.Command(*peerBinary, peerArgs...).CombinedOutput()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %s: %s: %s\n%s", inv.Source, inv.Function, err.Error(), output)
+					failed = true
+					continue
+				}
+				fmt.Printf("%s: %s submitted\n", inv.Source, inv.Function)
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//arguments of peer chaincode invoke for an invocation
+func invokeArgs(inv invocation, channel string, chaincode string, peerFlags string) ([]string, error) {
+	ctor := map[string][]string{"Args": append([]string{inv.Function}, inv.Args...)}
+	ctorAsByte, err := json.Marshal(ctor)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"chaincode", "invoke"}
+	args = append(args, strings.Fields(os.ExpandEnv(peerFlags))...)
+	args = append(args, "-C", channel, "-n", chaincode, "-c", string(ctorAsByte))
+
+	//private values stay out of the proposal args, the peer cli wants transient values base64 encoded
+	if inv.Input != nil {
+		inputAsByte, err := json.Marshal(inv.Input)
+		if err != nil {
+			return nil, err
+		}
+		transientAsByte, err := json.Marshal(map[string]string{"input": base64.StdEncoding.EncodeToString(inputAsByte)})
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--transient", string(transientAsByte))
+	}
+	return args, nil
+}
+
+//command line that can be pasted in the cli container
+func shellCommand(binary string, args []string) string {
+	quoted := []string{binary}
+	for _, arg := range args {
+		if strings.IndexFunc(arg, func(r rune) bool {
+			return !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=", r)
+		}) < 0 && len(arg) > 0 {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.Replace(arg, "'", `'"'"'`, -1)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//cells that name a chaincode function and start a block of rows, like createOrder()
+var functionCell = regexp.MustCompile(`^([A-Za-z]+)\(\)$`)
+
+//columns the test sheets use to log timings, they are not arguments
+var ignoredHeaders = map[string]bool{
+	"executetime": true,
+	"excutetime":  true,
+}
+
+//one row of input, values are keyed by normalized header
+type record struct {
+	Source   string
+	Function string
+	Values   map[string]string
+}
+
+//first non empty value among the aliases of a field
+func (r record) get(names ...string) string {
+	for _, name := range names {
+		if value := r.Values[name]; len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+//one chaincode invocation built from one or more rows, private values go in the transient input
+type invocation struct {
+	Source   string
+	Function string
+	Args     []string
+	Input    map[string]interface{}
+}
+
+//msp ids that qualify the bare enrollment ids of the sheets, like seller001, by role
+type orgs struct {
+	Customer string
+	Seller   string
+	Shipper  string
+}
+
+//identity of a participant, ids that already name an msp, like Org1MSP/seller001, are kept
+func qualify(mspID string, id string) string {
+	if len(id) == 0 || strings.Contains(id, "/") {
+		return id
+	}
+	return mspID + "/" + id
+}
+
+//headers are compared without case, spaces or underscores
+func normalizeHeader(header string) string {
+	header = strings.ToLower(header)
+	header = strings.Replace(header, " ", "", -1)
+	header = strings.Replace(header, "_", "", -1)
+	return header
+}
+
+//split a sheet into records, blocks start at a function cell with their headers on the same row
+//sheets without function cells have a header row and take the function from a function column or defaultFunction
+func extractRecords(s sheet, defaultFunction string) ([]record, []string) {
+	records := []record{}
+	notes := []string{}
+	found := false
+
+	for rowIndex, row := range s.Rows {
+		for column, cell := range row {
+			match := functionCell.FindStringSubmatch(cell)
+			if match == nil {
+				continue
+			}
+			found = true
+
+			//headers run to the right until the next block
+			headers := map[int]string{}
+			for next := column + 1; next < len(row); next++ {
+				if functionCell.MatchString(row[next]) {
+					break
+				}
+				header := normalizeHeader(row[next])
+				if len(header) > 0 && !ignoredHeaders[header] {
+					headers[next] = header
+				}
+			}
+			if len(headers) == 0 {
+				notes = append(notes, fmt.Sprintf("%s!%s: block %s has no headers", s.Name, cellName(column, rowIndex), cell))
+				continue
+			}
+
+			for dataIndex := rowIndex + 1; dataIndex < len(s.Rows); dataIndex++ {
+				values := map[string]string{}
+				for headerColumn, header := range headers {
+					if headerColumn < len(s.Rows[dataIndex]) && len(s.Rows[dataIndex][headerColumn]) > 0 {
+						values[header] = s.Rows[dataIndex][headerColumn]
+					}
+				}
+				if len(values) == 0 {
+					break
+				}
+				records = append(records, record{fmt.Sprintf("%s!%d", s.Name, dataIndex+1), match[1], values})
+			}
+		}
+	}
+	if found {
+		return records, notes
+	}
+
+	//plain table, first non empty row holds the headers
+	headerIndex := -1
+	for rowIndex, row := range s.Rows {
+		if len(strings.Join(row, "")) > 0 {
+			headerIndex = rowIndex
+			break
+		}
+	}
+	if headerIndex < 0 {
+		return records, notes
+	}
+	headers := map[int]string{}
+	for column, cell := range s.Rows[headerIndex] {
+		if header := normalizeHeader(cell); len(header) > 0 && !ignoredHeaders[header] {
+			headers[column] = header
+		}
+	}
+	for dataIndex := headerIndex + 1; dataIndex < len(s.Rows); dataIndex++ {
+		values := map[string]string{}
+		for column, header := range headers {
+			if column < len(s.Rows[dataIndex]) && len(s.Rows[dataIndex][column]) > 0 {
+				values[header] = s.Rows[dataIndex][column]
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		function := values["function"]
+		if len(function) == 0 {
+			function = defaultFunction
+		}
+		function = strings.TrimSuffix(function, "()")
+		if len(function) == 0 {
+			notes = append(notes, fmt.Sprintf("%s: no function column and no -function given, sheet skipped", s.Name))
+			return []record{}, notes
+		}
+		records = append(records, record{fmt.Sprintf("%s!%d", s.Name, dataIndex+1), function, values})
+	}
+	return records, notes
+}
+
+//turn records into invocations, rows of createOrder sharing an order id become one multi-line order
+func buildInvocations(records []record, o orgs) ([]invocation, []string, []string) {
+	invocations := []invocation{}
+	errors := []string{}
+	notes := []string{}
+
+	for i := 0; i < len(records); i++ {
+		r := records[i]
+		var args []string
+		var input map[string]interface{}
+		var err error
+
+		switch r.Function {
+		case "createCustomer":
+			args, input, err = customerArgs(r, o)
+		case "createAsset":
+			args, input, err = assetArgs(r, o)
+		case "createDelivery":
+			args, input, err = deliveryArgs(r, o)
+		case "createBalance":
+			args, input, err = balanceArgs(r, o)
+		case "createOrder":
+			group := []record{r}
+			orderID := r.get("orderid", "id")
+			for len(orderID) > 0 && i+1 < len(records) && records[i+1].Function == "createOrder" && records[i+1].get("orderid", "id") == orderID {
+				i++
+				group = append(group, records[i])
+			}
+			args, input, err = orderArgs(group, o)
+		default:
+			notes = append(notes, fmt.Sprintf("%s: %s is not imported, row skipped", r.Source, r.Function))
+			continue
+		}
+
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %s: %s", r.Source, r.Function, err.Error()))
+			continue
+		}
+		invocations = append(invocations, invocation{r.Source, r.Function, args, input})
+	}
+	return invocations, errors, notes
+}
+
+//participants are registered under an identity, msp id and enrollment id, only admins may give one that is not theirs
+func withIdentity(identity string) []string {
+	if len(identity) > 0 {
+		return []string{identity}
+	}
+	return []string{}
+}
+
+//same rules as createCustomer: identity (optional) in args, name, location, number, email in the transient input
+func customerArgs(r record, o orgs) ([]string, map[string]interface{}, error) {
+	name := r.get("name", "customer")
+	location := r.get("location", "address")
+	number := r.get("number", "phone")
+	email := r.get("email")
+	if len(name) == 0 {
+		return nil, nil, fmt.Errorf("Customer's name must be declare")
+	}
+	if len(location) == 0 {
+		return nil, nil, fmt.Errorf("Customer's location must be declare")
+	}
+	if len(number) == 0 {
+		return nil, nil, fmt.Errorf("Customer's number must be declare")
+	}
+	if len(email) == 0 {
+		return nil, nil, fmt.Errorf("Customer's email must be declare")
+	}
+	input := map[string]interface{}{"name": name, "location": location, "number": number, "email": email}
+	return withIdentity(qualify(o.Customer, r.get("identity", "id", "customerid", "buyerid"))), input, nil
+}
+
+//same rules as createAsset: identity of seller (optional) in args, name of seller, asset, quantity, price in the transient input
+func assetArgs(r record, o orgs) ([]string, map[string]interface{}, error) {
+	seller := r.get("sellername", "seller")
+	asset := r.get("asset", "assetname", "name")
+	if len(seller) == 0 {
+		return nil, nil, fmt.Errorf("name of seller must be declare")
+	}
+	if len(asset) == 0 {
+		return nil, nil, fmt.Errorf("name of asset must be declare")
+	}
+	if strings.Contains(asset, "/") {
+		return nil, nil, fmt.Errorf("name of asset must not contain /")
+	}
+	quantity, err := strconv.Atoi(r.get("quantity"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("quantity must be a number")
+	}
+	price, err := strconv.Atoi(r.get("price"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("price must be a number")
+	}
+	if quantity < 0 {
+		return nil, nil, fmt.Errorf("quantity must not be negative")
+	}
+	if price < 0 {
+		return nil, nil, fmt.Errorf("price must not be negative")
+	}
+	input := map[string]interface{}{"sellername": seller, "asset": asset, "quantity": quantity, "price": price}
+	return withIdentity(qualify(o.Seller, r.get("identity", "id", "sellerid"))), input, nil
+}
+
+//same rules as createDelivery: identity (optional) in args, name, location, price, distance in km, time in hours in the transient input
+func deliveryArgs(r record, o orgs) ([]string, map[string]interface{}, error) {
+	name := r.get("name", "delivery", "shipper")
+	if len(name) == 0 {
+		return nil, nil, fmt.Errorf("name of delivery must be declare")
+	}
+	price, err := strconv.Atoi(r.get("price"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("price must be a number")
+	}
+	distance, err := strconv.Atoi(r.get("distance"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("distance must be a number of km")
+	}
+	hours, err := strconv.Atoi(r.get("time", "hours"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("time must be a number of hours")
+	}
+	if price < 0 {
+		return nil, nil, fmt.Errorf("price must not be negative")
+	}
+	if distance < 0 || hours < 0 {
+		return nil, nil, fmt.Errorf("distance and time must not be negative")
+	}
+	input := map[string]interface{}{"name": name, "location": r.get("location"), "price": price, "distance": distance, "time": hours}
+	return withIdentity(qualify(o.Shipper, r.get("identity", "id", "deliverid", "deliveryid"))), input, nil
+}
+
+//same rules as createBalance: identity (optional) in args, name, balance, balance or mortgage in the transient input
+//older sheets name the org of the balance, or mortage for collateral, and give the owner's enrollment id as name
+func balanceArgs(r record, o orgs) ([]string, map[string]interface{}, error) {
+	name := r.get("name", "owner")
+	kind := r.get("kind", "collection")
+	identity := r.get("identity", "id")
+	if len(name) == 0 {
+		return nil, nil, fmt.Errorf("name must be declare")
+	}
+	balance, err := strconv.Atoi(r.get("balance"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("balance must be a number")
+	}
+
+	//the chaincode picks the org collection from the owner's identity
+	switch kind {
+	case "Org1", "Org2":
+		if len(identity) == 0 {
+			identity = name
+		}
+		identity = qualify(kind+"MSP", identity)
+		kind = "balance"
+	case "mortage", "mortgage":
+		//collateral is held by shippers
+		if len(identity) == 0 {
+			identity = name
+		}
+		identity = qualify(o.Shipper, identity)
+		kind = "mortgage"
+	case "balance":
+	default:
+		return nil, nil, fmt.Errorf("kind must be balance or mortgage, not %q", kind)
+	}
+	input := map[string]interface{}{"name": name, "balance": balance, "kind": kind}
+	return withIdentity(identity), input, nil
+}
+
+type orderItem struct {
+	Asset     string `json:"asset"`
+	Variant   string `json:"variant"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int    `json:"unitprice"`
+}
+
+//same rules as createOrder: identities of customer, seller and delivery in args, detail, items and an optional amount in the transient input
+//order id and status columns are ignored, the chaincode mints the id and starts every order as created
+//price columns are ignored too, lines are priced from the seller's asset unless a unitprice column is given
+func orderArgs(group []record, o orgs) ([]string, map[string]interface{}, error) {
+	first := group[0]
+	customer := first.get("customer", "customerid", "buyerid", "buyer")
+	seller := first.get("seller", "sellerid")
+	delivery := first.get("delivery", "deliverid", "deliveryid", "shipper")
+	amount := first.get("amount", "total")
+	if len(customer) == 0 {
+		return nil, nil, fmt.Errorf("customer must be declare")
+	}
+	if len(seller) == 0 {
+		return nil, nil, fmt.Errorf("seller must be declare")
+	}
+	if len(delivery) == 0 {
+		return nil, nil, fmt.Errorf("delivery must be declare")
+	}
+
+	items := []orderItem{}
+	for i, r := range group {
+		if r.get("customer", "customerid", "buyerid", "buyer") != customer || r.get("seller", "sellerid") != seller {
+			return nil, nil, fmt.Errorf("%s: every line of an order must have the same customer and seller", r.Source)
+		}
+		item := orderItem{Asset: r.get("asset", "assetname", "name"), Variant: r.get("variant")}
+		if len(item.Asset) == 0 {
+			return nil, nil, fmt.Errorf("asset of item %d must be declare", i)
+		}
+		quantity, err := strconv.Atoi(r.get("quantity"))
+		if err != nil || quantity <= 0 {
+			return nil, nil, fmt.Errorf("quantity of item %d must be greater than 0", i)
+		}
+		item.Quantity = quantity
+		if unitPrice := r.get("unitprice"); len(unitPrice) > 0 {
+			item.UnitPrice, err = strconv.Atoi(unitPrice)
+			if err != nil || item.UnitPrice < 0 {
+				return nil, nil, fmt.Errorf("unit price of item %d must not be negative", i)
+			}
+		}
+		items = append(items, item)
+	}
+
+	input := map[string]interface{}{"detail": first.get("detail"), "items": items}
+	if len(amount) > 0 {
+		total, err := strconv.Atoi(amount)
+		if err != nil {
+			return nil, nil, fmt.Errorf("amount must be a number")
+		}
+		input["amount"] = total
+	}
+	return []string{qualify(o.Customer, customer), qualify(o.Seller, seller), qualify(o.Shipper, delivery)}, input, nil
+}
+
+//spreadsheet name of a zero based cell, like A6
+func cellName(column int, row int) string {
+	name := ""
+	for column = column + 1; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name + strconv.Itoa(row+1)
+}
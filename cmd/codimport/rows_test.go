@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var testOrgs = orgs{"Org1MSP", "Org1MSP", "Org2MSP"}
+
+func TestExtractRecords(t *testing.T) {
+	tests := []struct {
+		name            string
+		rows            [][]string
+		defaultFunction string
+		records         []record
+		notes           int
+	}{
+		{
+			name: "blocks side by side",
+			rows: [][]string{
+				{"Cenario 1"},
+				{"createOrder()", "orderID", "buyerID", "execute time", "createBalance()", "name", "collection"},
+				{"", "order001", "customer001", "1.0s", "", "seller001", "Org1"},
+				{"", "order002", "customer002"},
+				{},
+			},
+			records: []record{
+				{"s!3", "createOrder", map[string]string{"orderid": "order001", "buyerid": "customer001"}},
+				{"s!4", "createOrder", map[string]string{"orderid": "order002", "buyerid": "customer002"}},
+				{"s!3", "createBalance", map[string]string{"name": "seller001", "collection": "Org1"}},
+			},
+		},
+		{
+			name: "block without headers",
+			rows: [][]string{
+				{"createOrder()"},
+				{"", "order001"},
+			},
+			records: []record{},
+			notes:   1,
+		},
+		{
+			name: "plain table with function column",
+			rows: [][]string{
+				{},
+				{"Function", "Sellername", "Asset"},
+				{"createAsset", "Shop", "Phone"},
+				{"", "", ""},
+				{"createAsset", "Shop", "Laptop"},
+			},
+			records: []record{
+				{"s!3", "createAsset", map[string]string{"function": "createAsset", "sellername": "Shop", "asset": "Phone"}},
+				{"s!5", "createAsset", map[string]string{"function": "createAsset", "sellername": "Shop", "asset": "Laptop"}},
+			},
+		},
+		{
+			name:            "plain table with default function",
+			rows:            [][]string{{"name", "balance"}, {"seller001", "10"}},
+			defaultFunction: "createBalance",
+			records:         []record{{"s!2", "createBalance", map[string]string{"name": "seller001", "balance": "10"}}},
+		},
+		{
+			name:    "plain table without function",
+			rows:    [][]string{{"name", "balance"}, {"seller001", "10"}},
+			records: []record{},
+			notes:   1,
+		},
+	}
+
+	for _, test := range tests {
+		records, notes := extractRecords(sheet{"s", test.rows}, test.defaultFunction)
+		if !reflect.DeepEqual(records, test.records) {
+			t.Errorf("%s: records %v, want %v", test.name, records, test.records)
+		}
+		if len(notes) != test.notes {
+			t.Errorf("%s: notes %v, want %d", test.name, notes, test.notes)
+		}
+	}
+}
+
+func orderRecord(source string, values map[string]string) record {
+	return record{source, "createOrder", values}
+}
+
+func TestBuildInvocationsGroupsOrders(t *testing.T) {
+	line := func(orderID string, asset string, quantity string) map[string]string {
+		return map[string]string{"orderid": orderID, "buyerid": "customer001", "sellerid": "seller001", "deliverid": "delivery001", "name": asset, "quantity": quantity, "price": "700"}
+	}
+	tests := []struct {
+		name    string
+		records []record
+		items   [][]orderItem
+		errors  int
+	}{
+		{
+			name: "lines of one order id",
+			records: []record{
+				orderRecord("s!2", line("order001", "Phone", "1")),
+				orderRecord("s!3", line("order001", "Case", "2")),
+				orderRecord("s!4", line("order002", "Phone", "1")),
+			},
+			items: [][]orderItem{
+				{{"Phone", "", 1, 0}, {"Case", "", 2, 0}},
+				{{"Phone", "", 1, 0}},
+			},
+		},
+		{
+			name: "rows without order id stay single",
+			records: []record{
+				orderRecord("s!2", line("", "Phone", "1")),
+				orderRecord("s!3", line("", "Case", "1")),
+			},
+			items: [][]orderItem{
+				{{"Phone", "", 1, 0}},
+				{{"Case", "", 1, 0}},
+			},
+		},
+		{
+			name: "same order id not adjacent",
+			records: []record{
+				orderRecord("s!2", line("order001", "Phone", "1")),
+				orderRecord("s!3", line("order002", "Phone", "1")),
+				orderRecord("s!4", line("order001", "Case", "1")),
+			},
+			items: [][]orderItem{
+				{{"Phone", "", 1, 0}},
+				{{"Phone", "", 1, 0}},
+				{{"Case", "", 1, 0}},
+			},
+		},
+		{
+			name: "bad line fails the whole order",
+			records: []record{
+				orderRecord("s!2", line("order001", "Phone", "1")),
+				orderRecord("s!3", line("order001", "Case", "0")),
+				orderRecord("s!4", line("order002", "Phone", "1")),
+			},
+			items:  [][]orderItem{{{"Phone", "", 1, 0}}},
+			errors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		invocations, errors, _ := buildInvocations(test.records, testOrgs)
+		if len(errors) != test.errors {
+			t.Errorf("%s: errors %v, want %d", test.name, errors, test.errors)
+		}
+		if len(invocations) != len(test.items) {
+			t.Errorf("%s: %d invocations, want %d", test.name, len(invocations), len(test.items))
+			continue
+		}
+		for i, inv := range invocations {
+			if !reflect.DeepEqual(inv.Input["items"], test.items[i]) {
+				t.Errorf("%s: items of order %d %v, want %v", test.name, i, inv.Input["items"], test.items[i])
+			}
+			want := []string{"Org1MSP/customer001", "Org1MSP/seller001", "Org2MSP/delivery001"}
+			if !reflect.DeepEqual(inv.Args, want) {
+				t.Errorf("%s: args %v, want %v", test.name, inv.Args, want)
+			}
+		}
+	}
+}
+
+func TestOrderArgsUnitPrice(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    map[string]string
+		unitPrice int
+		fails     bool
+	}{
+		{"price column is not a unit price", map[string]string{"price": "7190000"}, 0, false},
+		{"unit price column", map[string]string{"unitprice": "500", "price": "1000"}, 500, false},
+		{"negative unit price", map[string]string{"unitprice": "-1"}, 0, true},
+	}
+
+	for _, test := range tests {
+		values := map[string]string{"customer": "c", "seller": "s", "delivery": "d", "asset": "Phone", "quantity": "2"}
+		for name, value := range test.values {
+			values[name] = value
+		}
+		_, input, err := orderArgs([]record{orderRecord("s!2", values)}, testOrgs)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if items := input["items"].([]orderItem); items[0].UnitPrice != test.unitPrice {
+			t.Errorf("%s: unit price %d, want %d", test.name, items[0].UnitPrice, test.unitPrice)
+		}
+	}
+}
+
+func TestAssetArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		fails  bool
+	}{
+		{"asset of the workbook", map[string]string{}, false},
+		{"free asset", map[string]string{"price": "0"}, false},
+		{"negative quantity", map[string]string{"quantity": "-1"}, true},
+		{"negative price", map[string]string{"price": "-700"}, true},
+		{"asset name with a slash", map[string]string{"asset": "Phone/X"}, true},
+	}
+
+	for _, test := range tests {
+		values := map[string]string{"sellername": "Shop", "asset": "Phone", "quantity": "20", "price": "700"}
+		for name, value := range test.values {
+			values[name] = value
+		}
+		_, _, err := assetArgs(record{"s!2", "createAsset", values}, testOrgs)
+		if test.fails && err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+		if !test.fails && err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		}
+	}
+}
+
+func TestDeliveryArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		fails  bool
+	}{
+		{"delivery of the workbook", map[string]string{}, false},
+		{"negative price", map[string]string{"price": "-30"}, true},
+		{"negative distance", map[string]string{"distance": "-10"}, true},
+		{"negative time", map[string]string{"time": "-2"}, true},
+	}
+
+	for _, test := range tests {
+		values := map[string]string{"name": "delivery001", "location": "Hanoi", "price": "30", "distance": "10", "time": "2"}
+		for name, value := range test.values {
+			values[name] = value
+		}
+		_, input, err := deliveryArgs(record{"s!2", "createDelivery", values}, testOrgs)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if input["price"] != 30 || input["distance"] != 10 || input["time"] != 2 {
+			t.Errorf("%s: input %v", test.name, input)
+		}
+	}
+}
+
+func TestBalanceArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		args   []string
+		kind   string
+		fails  bool
+	}{
+		{"org of the workbook", map[string]string{"name": "seller001", "collection": "Org1"}, []string{"Org1MSP/seller001"}, "balance", false},
+		{"second org", map[string]string{"name": "delivery001", "collection": "Org2"}, []string{"Org2MSP/delivery001"}, "balance", false},
+		{"mortgage typo of the workbook", map[string]string{"name": "mortgage_account", "collection": "mortage"}, []string{"Org2MSP/mortgage_account"}, "mortgage", false},
+		{"identity column wins", map[string]string{"name": "Shop", "identity": "Org1MSP/seller002", "kind": "balance"}, []string{"Org1MSP/seller002"}, "balance", false},
+		{"caller's own balance", map[string]string{"name": "Shop", "kind": "balance"}, []string{}, "balance", false},
+		{"unknown kind", map[string]string{"name": "Shop", "kind": "savings"}, nil, "", true},
+	}
+
+	for _, test := range tests {
+		values := map[string]string{"balance": "10"}
+		for name, value := range test.values {
+			values[name] = value
+		}
+		args, input, err := balanceArgs(record{"s!2", "createBalance", values}, testOrgs)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: args %v, want %v", test.name, args, test.args)
+		}
+		if input["kind"] != test.kind {
+			t.Errorf("%s: kind %v, want %s", test.name, input["kind"], test.kind)
+		}
+	}
+}
+
+func TestInvokeArgsTransient(t *testing.T) {
+	inv := invocation{"s!2", "createBalance", []string{"Org1MSP/seller001"}, map[string]interface{}{"name": "seller001", "balance": 10, "kind": "balance"}}
+	args, err := invokeArgs(inv, "mychannel", "COD", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"chaincode", "invoke", "-C", "mychannel", "-n", "COD", "-c", `{"Args":["createBalance","Org1MSP/seller001"]}`, "--transient"}
+	if !reflect.DeepEqual(args[:len(want)], want) {
+		t.Fatalf("args %v, want %v", args, want)
+	}
+	transient := map[string][]byte{}
+	err = json.Unmarshal([]byte(args[len(want)]), &transient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(transient["input"]) != `{"balance":10,"kind":"balance","name":"seller001"}` {
+		t.Errorf("transient input %s", transient["input"])
+	}
+}
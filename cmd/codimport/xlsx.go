@@ -0,0 +1,218 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//a sheet of a workbook as rows of cell values, missing cells are empty strings
+type sheet struct {
+	Name string
+	Rows [][]string
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+//text of a shared or inline string, rich text is split in runs
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	text := ""
+	for _, run := range t.Runs {
+		text = text + run.T
+	}
+	return text
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+//read every sheet of an xlsx workbook
+func readXLSX(fileName string) ([]sheet, error) {
+	reader, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	files := map[string]*zip.File{}
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+
+	workbook := xlsxWorkbook{}
+	err = decodeZipXML(files, "xl/workbook.xml", &workbook)
+	if err != nil {
+		return nil, err
+	}
+	relationships := xlsxRelationships{}
+	err = decodeZipXML(files, "xl/_rels/workbook.xml.rels", &relationships)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, relationship := range relationships.Relationships {
+		targets[relationship.ID] = relationship.Target
+	}
+
+	//workbooks without text cells have no shared strings
+	sharedStrings := xlsxSharedStrings{}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		err = decodeZipXML(files, "xl/sharedStrings.xml", &sharedStrings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sheets := []sheet{}
+	for _, workbookSheet := range workbook.Sheets {
+		target := targets[workbookSheet.RID]
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+
+		worksheet := xlsxWorksheet{}
+		err = decodeZipXML(files, target, &worksheet)
+		if err != nil {
+			return nil, err
+		}
+
+		rows := [][]string{}
+		for _, row := range worksheet.Rows {
+			for _, cell := range row.Cells {
+				column, rowIndex, err := parseCellRef(cell.Ref)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", workbookSheet.Name, err.Error())
+				}
+				value := cell.Value
+				switch cell.Type {
+				case "s":
+					index, err := strconv.Atoi(cell.Value)
+					if err != nil || index >= len(sharedStrings.Items) {
+						return nil, fmt.Errorf("%s!%s: bad shared string", workbookSheet.Name, cell.Ref)
+					}
+					value = sharedStrings.Items[index].String()
+				case "inlineStr":
+					value = cell.Inline.String()
+				default:
+					value = normalizeNumber(value)
+				}
+
+				for len(rows) <= rowIndex {
+					rows = append(rows, []string{})
+				}
+				for len(rows[rowIndex]) <= column {
+					rows[rowIndex] = append(rows[rowIndex], "")
+				}
+				rows[rowIndex][column] = strings.TrimSpace(value)
+			}
+		}
+		sheets = append(sheets, sheet{workbookSheet.Name, rows})
+	}
+	return sheets, nil
+}
+
+//read a csv file as a single sheet named after the file
+func readCSV(fileName string) ([]sheet, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		for i := range row {
+			row[i] = strings.TrimSpace(row[i])
+		}
+	}
+	return []sheet{{path.Base(fileName), rows}}, nil
+}
+
+func decodeZipXML(files map[string]*zip.File, name string, v interface{}) error {
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("workbook has no %s", name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+//turn a cell reference like B12 into zero based column and row
+func parseCellRef(ref string) (int, int, error) {
+	column := 0
+	i := 0
+	for i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z' {
+		column = column*26 + int(ref[i]-'A'+1)
+		i++
+	}
+	row, err := strconv.Atoi(ref[i:])
+	if i == 0 || err != nil || row < 1 {
+		return 0, 0, fmt.Errorf("bad cell reference %s", ref)
+	}
+	return column - 1, row - 1, nil
+}
+
+//spreadsheets store whole numbers as floats, prices must stay plain integers
+func normalizeNumber(value string) string {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number != float64(int64(number)) {
+		return value
+	}
+	return strconv.FormatInt(int64(number), 10)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//write a workbook with the given parts to a temporary file
+func writeWorkbook(t *testing.T, parts map[string]string) string {
+	fileName := filepath.Join(t.TempDir(), "book.xlsx")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range parts {
+		part, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = part.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+const testWorkbook = `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="orders" r:id="rId1"/><sheet name="empty" r:id="rId2"/></sheets></workbook>`
+
+const testRelationships = `<Relationships>
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`
+
+func TestReadXLSX(t *testing.T) {
+	tests := []struct {
+		name   string
+		parts  map[string]string
+		sheets []sheet
+		fails  bool
+	}{
+		{
+			name: "shared, inline, rich and number cells",
+			parts: map[string]string{
+				"xl/workbook.xml":            testWorkbook,
+				"xl/_rels/workbook.xml.rels": testRelationships,
+				"xl/sharedStrings.xml":       `<sst><si><t>createOrder()</t></si><si><r><t>order</t></r><r><t>ID</t></r></si></sst>`,
+				"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="2"><c r="A2" t="s"><v>0</v></c><c r="C2" t="s"><v>1</v></c></row>
+<row r="3"><c r="B3" t="inlineStr"><is><t> order001 </t></is></c><c r="C3"><v>7190000.0</v></c><c r="D3"><v>1.5</v></c></row>
+</sheetData></worksheet>`,
+				"xl/worksheets/sheet2.xml": `<worksheet><sheetData/></worksheet>`,
+			},
+			sheets: []sheet{
+				{"orders", [][]string{{}, {"createOrder()", "", "orderID"}, {"", "order001", "7190000", "1.5"}}},
+				{"empty", [][]string{}},
+			},
+		},
+		{
+			name: "no shared strings",
+			parts: map[string]string{
+				"xl/workbook.xml":            `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="n" r:id="rId1"/></sheets></workbook>`,
+				"xl/_rels/workbook.xml.rels": testRelationships,
+				"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row><c r="A1"><v>3</v></c></row></sheetData></worksheet>`,
+			},
+			sheets: []sheet{{"n", [][]string{{"3"}}}},
+		},
+		{
+			name: "shared string out of range",
+			parts: map[string]string{
+				"xl/workbook.xml":            testWorkbook,
+				"xl/_rels/workbook.xml.rels": testRelationships,
+				"xl/sharedStrings.xml":       `<sst><si><t>a</t></si></sst>`,
+				"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row><c r="A1" t="s"><v>4</v></c></row></sheetData></worksheet>`,
+				"xl/worksheets/sheet2.xml":   `<worksheet><sheetData/></worksheet>`,
+			},
+			fails: true,
+		},
+		{
+			name: "bad cell reference",
+			parts: map[string]string{
+				"xl/workbook.xml":            testWorkbook,
+				"xl/_rels/workbook.xml.rels": testRelationships,
+				"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row><c r="1A"><v>4</v></c></row></sheetData></worksheet>`,
+				"xl/worksheets/sheet2.xml":   `<worksheet><sheetData/></worksheet>`,
+			},
+			fails: true,
+		},
+		{
+			name: "missing sheet part",
+			parts: map[string]string{
+				"xl/workbook.xml":            testWorkbook,
+				"xl/_rels/workbook.xml.rels": testRelationships,
+			},
+			fails: true,
+		},
+	}
+
+	for _, test := range tests {
+		sheets, err := readXLSX(writeWorkbook(t, test.parts))
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(sheets, test.sheets) {
+			t.Errorf("%s: sheets %q, want %q", test.name, sheets, test.sheets)
+		}
+	}
+}
+
+func TestBundledWorkbook(t *testing.T) {
+	sheets, err := readXLSX(filepath.Join("..", "..", "data_CoD_trieu.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sheets {
+		records, _ := extractRecords(s, "")
+		_, errors, _ := buildInvocations(records, testOrgs)
+		if len(errors) > 0 {
+			t.Errorf("%s: %v", s.Name, errors)
+		}
+	}
+}